@@ -0,0 +1,296 @@
+// Package client is a Go client for the store's JSON REST API.
+//
+//	c := client.New("http://localhost:8080")
+//	version, err := c.Set(ctx, "greeting", "hello", client.WithTTL(time.Minute))
+//	value, err := c.Get(ctx, "greeting")
+//
+// A Client is safe for concurrent use and keeps a pool of connections to the
+// server. Its commands belong to no server session; transactions and AUTH
+// go through a Session, see Client.Multi. Commands are sent as space
+// separated words, so keys and values must not be empty or contain spaces.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors returned for the corresponding server responses.
+var (
+	ErrNotFound        = errors.New("key not found")
+	ErrConditionNotMet = errors.New("condition not met")
+	ErrInvalidArgument = errors.New("arguments must not be empty or contain spaces")
+)
+
+// Error is a command rejected by the server, carrying the HTTP status and
+// the error message of the response.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("kvs: %s (status %d)", e.Message, e.Status)
+}
+
+// Defaults used by New unless overridden by an Option.
+const (
+	DefaultRetries      = 2
+	DefaultRetryBackoff = 50 * time.Millisecond
+	DefaultMaxIdleConns = 16
+)
+
+// Client sends commands to one server.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+	auth    func(*http.Request) // nil to send no credentials
+
+	// Settings of the pool New creates unless given an http.Client.
+	maxIdle   int
+	tlsConfig *tls.Config
+}
+
+// Option configures a Client created by New.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of a client with its own
+// connection pool.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetries sets how many times an idempotent read is retried after a
+// network error or a 502, 503 or 504 response. Writes are never retried.
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = n }
+}
+
+// WithRetryBackoff sets the wait before the first retry. It doubles for each
+// retry after that.
+func WithRetryBackoff(d time.Duration) Option {
+	return func(c *Client) { c.backoff = d }
+}
+
+// WithMaxIdleConns sets how many idle connections to the server are kept
+// open for reuse. It has no effect together with WithHTTPClient.
+func WithMaxIdleConns(n int) Option {
+	return func(c *Client) { c.maxIdle = n }
+}
+
+// WithTLSConfig sets the TLS settings of the connections to an https://
+// server, such as the CAs to trust and a client certificate. It has no
+// effect together with WithHTTPClient.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) { c.tlsConfig = config }
+}
+
+// WithToken authenticates every request with an API token of the server.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.auth = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	}
+}
+
+// WithBasicAuth authenticates every request with a username and password.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.auth = func(req *http.Request) { req.SetBasicAuth(username, password) }
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// "http://localhost:8080".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		retries: DefaultRetries,
+		backoff: DefaultRetryBackoff,
+		maxIdle: DefaultMaxIdleConns,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.http == nil {
+		c.http = pooledClient(c.maxIdle, c.tlsConfig)
+	}
+	return c
+}
+
+func pooledClient(maxIdle int, tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdle
+	transport.MaxIdleConnsPerHost = maxIdle
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport}
+}
+
+// Close closes the idle connections of the pool.
+func (c *Client) Close() {
+	c.http.CloseIdleConnections()
+}
+
+// reply is a decoded response body along with its status.
+type reply struct {
+	status  int
+	body    map[string]json.RawMessage
+	session string // the server session the command ran in, if any
+}
+
+// err returns the error the reply stands for, if any.
+func (r *reply) err() error {
+	switch r.status {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusNotModified, http.StatusPreconditionFailed:
+		return ErrConditionNotMet
+	}
+	var message string
+	json.Unmarshal(r.body["error"], &message)
+	if message == "" {
+		message = http.StatusText(r.status)
+	}
+	return &Error{Status: r.status, Message: message}
+}
+
+// field decodes the named field of the body into v.
+func (r *reply) field(name string, v interface{}) error {
+	raw, ok := r.body[name]
+	if !ok {
+		return fmt.Errorf("kvs: response has no %q field", name)
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// command joins the words of a command, checking that the server will split
+// it back into the same words.
+func command(words ...string) (string, error) {
+	if len(words) == 0 {
+		return "", ErrInvalidArgument
+	}
+	for _, w := range words {
+		if w == "" || strings.ContainsAny(w, " \t\r\n") {
+			return "", ErrInvalidArgument
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+// readCommands are sent with GET, all others with POST, following the
+// server's routes.
+var readCommands = map[string]bool{"GET": true, "QPOP": true, "BQPOP": true, "MGET": true, "SCAN": true, "KEYS": true, "INFO": true}
+
+// idempotent commands are safe to retry: running them twice has the same
+// effect as running them once.
+var idempotent = map[string]bool{"GET": true, "MGET": true, "SCAN": true, "KEYS": true, "INFO": true}
+
+// Do sends a single command and returns the decoded response body. It is the
+// escape hatch for commands without a typed method.
+func (c *Client) Do(ctx context.Context, words ...string) (map[string]json.RawMessage, error) {
+	r, err := c.do(ctx, words...)
+	if err != nil {
+		return nil, err
+	}
+	return r.body, r.err()
+}
+
+func (c *Client) do(ctx context.Context, words ...string) (*reply, error) {
+	return c.doIn(ctx, "", words...)
+}
+
+// doIn sends a command in the server session with the given token, or in
+// none if it is empty.
+func (c *Client) doIn(ctx context.Context, session string, words ...string) (*reply, error) {
+	cmd, err := command(words...)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToUpper(words[0])
+
+	method := http.MethodPost
+	if readCommands[name] {
+		method = http.MethodGet
+	}
+	body, _ := json.Marshal(map[string]string{"command": cmd})
+
+	attempts := 1
+	if idempotent[name] {
+		attempts += c.retries
+	}
+	return c.send(ctx, method, body, session, attempts)
+}
+
+// send posts body to the command endpoint, making up to attempts attempts
+// while the failure is temporary.
+func (c *Client) send(ctx context.Context, method string, body []byte, session string, attempts int) (*reply, error) {
+	backoff := c.backoff
+	for attempt := 1; ; attempt++ {
+		r, err := c.roundTrip(ctx, method, body, session)
+		if attempt >= attempts || !temporary(r, err) || ctx.Err() != nil {
+			return r, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func temporary(r *reply, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch r.status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) roundTrip(ctx context.Context, method string, body []byte, session string) (*reply, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.auth != nil {
+		c.auth(req)
+	}
+	if session != "" {
+		req.Header.Set(sessionHeader, session)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r := &reply{status: resp.StatusCode, session: resp.Header.Get(sessionHeader)}
+	if resp.StatusCode == http.StatusNotModified {
+		return r, nil // 304 responses have no body
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r.body); err != nil && resp.StatusCode < 300 {
+		// Error pages from proxies are not JSON; their status says enough.
+		return nil, fmt.Errorf("kvs: invalid response: %w", err)
+	}
+	return r, nil
+}
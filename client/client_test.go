@@ -0,0 +1,276 @@
+package client_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/client"
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/SinisterSup/kv-datastore/server"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newClient(t *testing.T) *client.Client {
+	t.Helper()
+	ts := httptest.NewServer(server.New(kvs.NewKeyValueStore()))
+	t.Cleanup(ts.Close)
+	c := client.New(ts.URL)
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestStrings(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	version, err := c.Set(ctx, "hello", "world")
+	if err != nil || version == 0 {
+		t.Fatalf("Set() FAILED: expected a version, but got %v, %v", version, err)
+	}
+	if _, err := c.Set(ctx, "hello", "again", client.IfNotExists()); err != client.ErrConditionNotMet {
+		t.Errorf("Set(NX) FAILED: expected %v, but got %v", client.ErrConditionNotMet, err)
+	}
+	if _, err := c.Set(ctx, "hello", "again", client.IfVersion(version+1)); err != client.ErrConditionNotMet {
+		t.Errorf("Set(IFVERSION) FAILED: expected %v, but got %v", client.ErrConditionNotMet, err)
+	}
+	if _, err := c.Set(ctx, "hello", "there", client.WithTTL(time.Minute), client.IfVersion(version)); err != nil {
+		t.Errorf("Set(IFVERSION) FAILED: %v", err)
+	}
+
+	if val, err := c.Get(ctx, "hello"); val != "there" || err != nil {
+		t.Errorf("Get() FAILED: expected there, but got %q, %v", val, err)
+	}
+	if _, err := c.Get(ctx, "missing"); err != client.ErrNotFound {
+		t.Errorf("Get() FAILED: expected %v, but got %v", client.ErrNotFound, err)
+	}
+
+	if err := c.MSet(ctx, "a", "1", "b", "2"); err != nil {
+		t.Errorf("MSet() FAILED: %v", err)
+	}
+	values, err := c.MGet(ctx, "a", "missing", "b")
+	if err != nil || len(values) != 3 || *values[0] != "1" || values[1] != nil || *values[2] != "2" {
+		t.Errorf("MGet() FAILED: got %v, %v", values, err)
+	}
+	if err := c.MSetNX(ctx, "a", "x", "c", "3"); err != client.ErrConditionNotMet {
+		t.Errorf("MSetNX() FAILED: expected %v, but got %v", client.ErrConditionNotMet, err)
+	}
+	if n, err := c.Del(ctx, "a", "b", "missing"); n != 2 || err != nil {
+		t.Errorf("Del() FAILED: expected 2, but got %v, %v", n, err)
+	}
+
+	if keys, err := c.Keys(ctx, "hel*"); len(keys) != 1 || err != nil {
+		t.Errorf("Keys() FAILED: got %v, %v", keys, err)
+	}
+	if cursor, keys, err := c.Scan(ctx, "0", "", 0); cursor != "0" || len(keys) != 1 || err != nil {
+		t.Errorf("Scan() FAILED: got %v, %v, %v", cursor, keys, err)
+	}
+
+	if _, err := c.Set(ctx, "with space", "value"); err != client.ErrInvalidArgument {
+		t.Errorf("Set() FAILED: expected %v, but got %v", client.ErrInvalidArgument, err)
+	}
+	if _, err := c.Do(ctx); err != client.ErrInvalidArgument {
+		t.Errorf("Do() FAILED: expected %v, but got %v", client.ErrInvalidArgument, err)
+	}
+	var serverErr *client.Error
+	if _, err := c.Do(ctx, "NOSUCHCOMMAND"); !errors.As(err, &serverErr) || serverErr.Status != http.StatusBadRequest {
+		t.Errorf("Do() FAILED: expected a 400 error, but got %v", err)
+	}
+}
+
+func TestQueues(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	if err := c.QPush(ctx, "q", "a", "b", "c"); err != nil {
+		t.Fatalf("QPush() FAILED: %v", err)
+	}
+	if val, err := c.QPop(ctx, "q"); val != "c" || err != nil {
+		t.Errorf("QPop() FAILED: expected c, but got %q, %v", val, err)
+	}
+	if val, err := c.BQPop(ctx, "q", 0); val != "a" || err != nil {
+		t.Errorf("BQPop() FAILED: expected a, but got %q, %v", val, err)
+	}
+	c.QPop(ctx, "q")
+	if _, err := c.QPop(ctx, "q"); err != client.ErrNotFound {
+		t.Errorf("QPop() FAILED: expected %v, but got %v", client.ErrNotFound, err)
+	}
+	if _, err := c.BQPop(ctx, "q", 0); err != client.ErrNotFound {
+		t.Errorf("BQPop() FAILED: expected %v, but got %v", client.ErrNotFound, err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	p := c.Pipeline()
+	set := p.Set("k", "v")
+	nx := p.Set("k", "w", client.IfNotExists())
+	get := p.Get("k")
+	missing := p.Get("missing")
+	push := p.QPush("q", "x")
+	pop := p.QPop("q")
+
+	if _, err := get.Value(); err == nil {
+		t.Errorf("Value() FAILED: expected an error before Exec")
+	}
+	if err := p.Exec(ctx); err != nil {
+		t.Fatalf("Exec() FAILED: %v", err)
+	}
+
+	if v, err := set.Version(); v == 0 || err != nil {
+		t.Errorf("Set FAILED: expected a version, but got %v, %v", v, err)
+	}
+	if err := nx.Err(); err != client.ErrConditionNotMet {
+		t.Errorf("Set NX FAILED: expected %v, but got %v", client.ErrConditionNotMet, err)
+	}
+	if val, err := get.Value(); val != "v" || err != nil {
+		t.Errorf("Get FAILED: expected v, but got %q, %v", val, err)
+	}
+	if err := missing.Err(); err != client.ErrNotFound {
+		t.Errorf("Get FAILED: expected %v, but got %v", client.ErrNotFound, err)
+	}
+	if err := push.Err(); err != nil {
+		t.Errorf("QPush FAILED: %v", err)
+	}
+	if val, err := pop.Value(); val != "x" || err != nil {
+		t.Errorf("QPop FAILED: expected x, but got %q, %v", val, err)
+	}
+	if p.Len() != 0 {
+		t.Errorf("Exec() FAILED: expected the pipeline to be emptied")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	var expire atomic.Bool
+	srv := server.New(kvs.NewKeyValueStore())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if expire.Load() && r.Header.Get("X-Session-Id") != "" {
+			r.Header.Set("X-Session-Id", "expired") // as if it idled out
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	c := client.New(ts.URL)
+	ctx := context.Background()
+
+	tx, err := c.Multi(ctx)
+	if err != nil || tx.ID() == "" {
+		t.Fatalf("Multi() FAILED: expected a session, but got %q, %v", tx.ID(), err)
+	}
+	if _, err := tx.Do(ctx, "SET", "k", "queued"); err != nil {
+		t.Errorf("Do() FAILED: %v", err)
+	}
+	// The Client's own commands do not join the transaction.
+	if _, err := c.Set(ctx, "other", "v"); err != nil {
+		t.Errorf("Set() FAILED: expected it to run outside the transaction, but got %v", err)
+	}
+	if _, err := c.Get(ctx, "k"); err != client.ErrNotFound {
+		t.Errorf("Get() FAILED: expected k to wait for EXEC, but got %v", err)
+	}
+	if _, err := tx.Do(ctx, "EXEC"); err != nil || tx.ID() != "" {
+		t.Errorf("EXEC FAILED: expected the session to end, but got %q, %v", tx.ID(), err)
+	}
+	if val, err := c.Get(ctx, "k"); val != "queued" || err != nil {
+		t.Errorf("Get() FAILED: expected queued, but got %q, %v", val, err)
+	}
+
+	// A session that expired outside a transaction starts over.
+	w, err := c.Watch(ctx, "k")
+	if err != nil {
+		t.Fatalf("Watch() FAILED: %v", err)
+	}
+	expire.Store(true)
+	if _, err := w.Do(ctx, "GET", "k"); err != nil || w.ID() != "" {
+		t.Errorf("Do() FAILED: expected a retry without the session, but got %q, %v", w.ID(), err)
+	}
+
+	// Inside one, the queued commands are lost, so the error is reported.
+	expire.Store(false)
+	tx, _ = c.Multi(ctx)
+	expire.Store(true)
+	var serverErr *client.Error
+	if _, err := tx.Do(ctx, "SET", "k", "lost"); !errors.As(err, &serverErr) || serverErr.Status != http.StatusBadRequest {
+		t.Errorf("Do() FAILED: expected the expired session error, but got %v", err)
+	}
+	if val, _ := c.Get(ctx, "k"); val != "queued" {
+		t.Errorf("Do() FAILED: expected the command not to run outside the transaction, but k is %q", val)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := server.New(kvs.NewKeyValueStore())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	c := client.New(ts.URL, client.WithRetryBackoff(time.Millisecond))
+	ctx := context.Background()
+
+	if _, err := c.Get(ctx, "k"); err != client.ErrNotFound {
+		t.Errorf("Get() FAILED: expected the retry to reach the server, but got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Get() FAILED: expected 2 attempts, but got %v", calls.Load())
+	}
+
+	calls.Store(0)
+	var serverErr *client.Error
+	if _, err := c.Set(ctx, "k", "v"); !errors.As(err, &serverErr) || serverErr.Status != http.StatusServiceUnavailable {
+		t.Errorf("Set() FAILED: expected writes not to be retried, but got %v", err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	c := newClient(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.BQPop(ctx, "empty", time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("BQPop() FAILED: expected %v, but got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv := server.New(kvs.NewKeyValueStore())
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	sum := sha256.Sum256([]byte("s3cret"))
+	users := "users:\n  - name: alice\n    passwords: [sha256:" + hex.EncodeToString(sum[:]) + "]\n    tokens: [sha256:" + hex.EncodeToString(sum[:]) + "]\n"
+	if err := os.WriteFile(path, []byte(users), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetAuthFile(path); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	ctx := context.Background()
+
+	var e *client.Error
+	if _, err := client.New(ts.URL).Get(ctx, "key"); !errors.As(err, &e) || e.Status != http.StatusUnauthorized {
+		t.Errorf("Get() FAILED: expected status 401, but got %v", err)
+	}
+	for _, opt := range []client.Option{client.WithToken("s3cret"), client.WithBasicAuth("alice", "s3cret")} {
+		if _, err := client.New(ts.URL, opt).Get(ctx, "key"); err != client.ErrNotFound {
+			t.Errorf("Get() FAILED: expected %v, but got %v", client.ErrNotFound, err)
+		}
+	}
+}
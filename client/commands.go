@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// SetOption modifies a Set call.
+type SetOption func([]string) []string
+
+// WithTTL makes the key expire after d, rounded down to whole seconds.
+func WithTTL(d time.Duration) SetOption {
+	return func(words []string) []string {
+		return append(words, "EX", strconv.Itoa(int(d/time.Second)))
+	}
+}
+
+// IfNotExists only sets the key if it does not exist (SET NX).
+func IfNotExists() SetOption {
+	return func(words []string) []string { return append(words, "NX") }
+}
+
+// IfExists only sets the key if it already exists (SET XX).
+func IfExists() SetOption {
+	return func(words []string) []string { return append(words, "XX") }
+}
+
+// IfVersion only sets the key if its current version is version, 0 meaning
+// it must not exist (SET IFVERSION). It can only be combined with WithTTL.
+func IfVersion(version uint64) SetOption {
+	return func(words []string) []string {
+		return append(words, "IFVERSION", strconv.FormatUint(version, 10))
+	}
+}
+
+func setCommand(key, value string, opts []SetOption) []string {
+	words := []string{"SET", key, value}
+	for _, opt := range opts {
+		words = opt(words)
+	}
+	return words
+}
+
+// Set stores value at key and returns the version the key was given. It
+// returns ErrConditionNotMet if a condition option ruled the write out.
+func (c *Client) Set(ctx context.Context, key, value string, opts ...SetOption) (uint64, error) {
+	r, err := c.do(ctx, setCommand(key, value, opts)...)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.err(); err != nil {
+		return 0, err
+	}
+	var version uint64
+	return version, r.field("version", &version)
+}
+
+// Get returns the value at key, or ErrNotFound.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	value, _, err := c.GetWithVersion(ctx, key)
+	return value, err
+}
+
+// GetWithVersion is Get, also returning the key's current version.
+func (c *Client) GetWithVersion(ctx context.Context, key string) (string, uint64, error) {
+	r, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := r.err(); err != nil {
+		return "", 0, err
+	}
+	var value string
+	var version uint64
+	if err := r.field("value", &value); err != nil {
+		return "", 0, err
+	}
+	return value, version, r.field("version", &version)
+}
+
+// MGet returns the values at keys, with nil for the keys that do not exist.
+func (c *Client) MGet(ctx context.Context, keys ...string) ([]*string, error) {
+	r, err := c.do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	var values []*string
+	return values, r.field("values", &values)
+}
+
+// MSet sets several keys at once, given as alternating keys and values.
+func (c *Client) MSet(ctx context.Context, pairs ...string) error {
+	return c.exec(ctx, append([]string{"MSET"}, pairs...)...)
+}
+
+// MSetNX is MSet, but sets nothing and returns ErrConditionNotMet if any of
+// the keys exists.
+func (c *Client) MSetNX(ctx context.Context, pairs ...string) error {
+	return c.exec(ctx, append([]string{"MSETNX"}, pairs...)...)
+}
+
+// Del removes the given keys and returns how many of them existed.
+func (c *Client) Del(ctx context.Context, keys ...string) (int, error) {
+	r, err := c.do(ctx, append([]string{"DEL"}, keys...)...)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.err(); err != nil {
+		return 0, err
+	}
+	var deleted int
+	return deleted, r.field("deleted", &deleted)
+}
+
+// QPush appends values to the queue at key, creating it if needed.
+func (c *Client) QPush(ctx context.Context, key string, values ...string) error {
+	return c.exec(ctx, append([]string{"QPUSH", key}, values...)...)
+}
+
+// QPop removes and returns the newest value of the queue at key. It returns
+// ErrNotFound if the queue is empty or does not exist.
+func (c *Client) QPop(ctx context.Context, key string) (string, error) {
+	return c.value(ctx, "QPOP", key)
+}
+
+// BQPop removes and returns the oldest value of the queue at key, waiting up
+// to timeout, in whole seconds, for one to be pushed. It returns ErrNotFound
+// if the queue is still empty after that. ctx must allow for the wait.
+func (c *Client) BQPop(ctx context.Context, key string, timeout time.Duration) (string, error) {
+	value, err := c.value(ctx, "BQPOP", key, strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+	if err == nil && value == "" {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+// Scan returns a page of keys matching pattern, all keys if it is empty,
+// and the cursor to pass to get the next page. Iteration starts and ends
+// with the cursor "0". count is a hint for the page size, 0 for the default.
+func (c *Client) Scan(ctx context.Context, cursor, pattern string, count int) (string, []string, error) {
+	words := []string{"SCAN", cursor}
+	if pattern != "" {
+		words = append(words, "MATCH", pattern)
+	}
+	if count > 0 {
+		words = append(words, "COUNT", strconv.Itoa(count))
+	}
+	r, err := c.do(ctx, words...)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := r.err(); err != nil {
+		return "", nil, err
+	}
+	var keys []string
+	if err := r.field("cursor", &cursor); err != nil {
+		return "", nil, err
+	}
+	return cursor, keys, r.field("keys", &keys)
+}
+
+// Keys returns every key matching pattern. Prefer Scan on large datasets.
+func (c *Client) Keys(ctx context.Context, pattern string) ([]string, error) {
+	r, err := c.do(ctx, "KEYS", pattern)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	var keys []string
+	return keys, r.field("keys", &keys)
+}
+
+// Info returns the server's INFO fields by section, for the given sections
+// or all of them.
+func (c *Client) Info(ctx context.Context, sections ...string) (map[string]map[string]any, error) {
+	r, err := c.do(ctx, append([]string{"INFO"}, sections...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	var info map[string]map[string]any
+	return info, r.field("info", &info)
+}
+
+// Publish sends message on channel and returns how many subscribers got it.
+func (c *Client) Publish(ctx context.Context, channel, message string) (int, error) {
+	r, err := c.do(ctx, "PUBLISH", channel, message)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.err(); err != nil {
+		return 0, err
+	}
+	var receivers int
+	return receivers, r.field("receivers", &receivers)
+}
+
+// exec runs a command whose response carries nothing but success.
+func (c *Client) exec(ctx context.Context, words ...string) error {
+	r, err := c.do(ctx, words...)
+	if err != nil {
+		return err
+	}
+	return r.err()
+}
+
+// value runs a command answering with a single value.
+func (c *Client) value(ctx context.Context, words ...string) (string, error) {
+	r, err := c.do(ctx, words...)
+	if err != nil {
+		return "", err
+	}
+	if err := r.err(); err != nil {
+		return "", err
+	}
+	var value string
+	return value, r.field("value", &value)
+}
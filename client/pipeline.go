@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Pipeline queues commands and sends them in one request. Each queued
+// command returns a Reply that is filled in by Exec.
+//
+//	p := c.Pipeline()
+//	set := p.Set("a", "1")
+//	get := p.Get("b")
+//	if err := p.Exec(ctx); err != nil { ... }
+//	value, err := get.Value()
+//
+// Commands run in order but not atomically; use Client.Multi for that. A
+// pipeline is never retried since it may contain writes.
+type Pipeline struct {
+	c        *Client
+	commands []string
+	replies  []*Reply
+	err      error // first invalid command, reported by Exec
+}
+
+// Reply is the result of one pipelined command.
+type Reply struct {
+	r   reply
+	err error // set when the pipeline itself failed
+}
+
+// Pipeline returns an empty pipeline.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// Do queues any command.
+func (p *Pipeline) Do(words ...string) *Reply {
+	reply := &Reply{err: errNotExecuted}
+	cmd, err := command(words...)
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	p.commands = append(p.commands, cmd)
+	p.replies = append(p.replies, reply)
+	return reply
+}
+
+var errNotExecuted = errors.New("kvs: pipeline not executed")
+
+func (p *Pipeline) Set(key, value string, opts ...SetOption) *Reply {
+	return p.Do(setCommand(key, value, opts)...)
+}
+
+func (p *Pipeline) Get(key string) *Reply {
+	return p.Do("GET", key)
+}
+
+func (p *Pipeline) Del(keys ...string) *Reply {
+	return p.Do(append([]string{"DEL"}, keys...)...)
+}
+
+func (p *Pipeline) QPush(key string, values ...string) *Reply {
+	return p.Do(append([]string{"QPUSH", key}, values...)...)
+}
+
+func (p *Pipeline) QPop(key string) *Reply {
+	return p.Do("QPOP", key)
+}
+
+func (p *Pipeline) BQPop(key string, timeout time.Duration) *Reply {
+	return p.Do("BQPOP", key, strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+}
+
+// Len returns the number of queued commands.
+func (p *Pipeline) Len() int {
+	return len(p.commands)
+}
+
+// Exec sends the queued commands and fills in their replies. It only fails
+// if the request as a whole did; errors of single commands are reported by
+// their Reply. The pipeline is empty again afterwards.
+func (p *Pipeline) Exec(ctx context.Context) error {
+	commands, replies, err := p.commands, p.replies, p.err
+	p.commands, p.replies, p.err = nil, nil, nil
+
+	if err != nil {
+		return err
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	body, _ := json.Marshal(map[string][]string{"commands": commands})
+	r, err := p.c.send(ctx, http.MethodPost, body, "", 1)
+	if err == nil {
+		err = r.err()
+	}
+	var results []map[string]json.RawMessage
+	if err == nil {
+		err = r.field("results", &results)
+	}
+	if err == nil && len(results) != len(replies) {
+		err = errors.New("kvs: pipeline response does not match the commands sent")
+	}
+	if err != nil {
+		for _, reply := range replies {
+			reply.err = err
+		}
+		return err
+	}
+
+	for i, reply := range replies {
+		reply.err = nil
+		reply.r.body = results[i]
+		reply.r.field("status", &reply.r.status)
+	}
+	return nil
+}
+
+// Err returns the command's error, if it failed.
+func (r *Reply) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.r.err()
+}
+
+// Value returns the value answered by GET, QPOP or BQPOP.
+func (r *Reply) Value() (string, error) {
+	if err := r.Err(); err != nil {
+		return "", err
+	}
+	var value string
+	return value, r.r.field("value", &value)
+}
+
+// Version returns the version answered by SET or GET.
+func (r *Reply) Version() (uint64, error) {
+	if err := r.Err(); err != nil {
+		return 0, err
+	}
+	var version uint64
+	return version, r.r.field("version", &version)
+}
+
+// Decode decodes the named field of the command's response into v, for
+// replies without a dedicated accessor.
+func (r *Reply) Decode(name string, v interface{}) error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+	return r.r.field(name, v)
+}
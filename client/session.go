@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// sessionHeader carries the session the server started for MULTI, WATCH or
+// AUTH, as server.SessionHeader.
+const sessionHeader = "X-Session-Id"
+
+// errInvalidSession is the error of a request sent with a session the server
+// no longer has.
+const errInvalidSession = "invalid or expired session"
+
+// Session sends commands in one server session, so that they can share a
+// transaction or an AUTH. The commands of other Sessions and of the Client
+// itself never join it.
+//
+//	tx, err := c.Multi(ctx)
+//	tx.Do(ctx, "SET", "a", "1") // queued
+//	tx.Do(ctx, "EXEC")
+//
+// EXEC and DISCARD end the session, unless AUTH authenticated it. Unlike a
+// Client, a Session is not safe for concurrent use.
+type Session struct {
+	c       *Client
+	id      string // "" until the server starts a session
+	inMulti bool   // between MULTI and EXEC or DISCARD
+	authed  bool   // AUTH succeeded, so the session outlives transactions
+}
+
+// NewSession returns a Session the server has not started yet; MULTI, WATCH
+// or AUTH sent with it start one.
+func (c *Client) NewSession() *Session {
+	return &Session{c: c}
+}
+
+// Multi starts a transaction: the commands sent with the returned Session
+// are queued until its EXEC.
+func (c *Client) Multi(ctx context.Context) (*Session, error) {
+	s := c.NewSession()
+	if _, err := s.Do(ctx, "MULTI"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Watch watches keys for an optimistic transaction, to be started with MULTI
+// on the returned Session. Its EXEC fails if any of the keys changed.
+func (c *Client) Watch(ctx context.Context, keys ...string) (*Session, error) {
+	s := c.NewSession()
+	if _, err := s.Do(ctx, append([]string{"WATCH"}, keys...)...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ID returns the token of the server session, or "" if none is open.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Do sends a command in the session and returns the decoded response body,
+// as Client.Do.
+func (s *Session) Do(ctx context.Context, words ...string) (map[string]json.RawMessage, error) {
+	r, err := s.do(ctx, words...)
+	if err != nil {
+		return nil, err
+	}
+	return r.body, r.err()
+}
+
+func (s *Session) do(ctx context.Context, words ...string) (*reply, error) {
+	r, err := s.c.doIn(ctx, s.id, words...)
+	if err != nil {
+		return nil, err
+	}
+	if s.id != "" && r.invalidSession() {
+		// Commands queued by a transaction are lost with its session, so
+		// only a session outside one starts over.
+		lost := s.inMulti
+		*s = Session{c: s.c}
+		if lost {
+			return r, nil
+		}
+		if r, err = s.c.doIn(ctx, "", words...); err != nil {
+			return nil, err
+		}
+	}
+	if r.session != "" {
+		s.id = r.session
+	}
+
+	switch strings.ToUpper(words[0]) {
+	case "MULTI":
+		s.inMulti = s.inMulti || r.err() == nil
+	case "AUTH":
+		s.authed = s.authed || r.err() == nil
+	case "EXEC", "DISCARD":
+		s.inMulti = false
+		if !s.authed {
+			s.id = ""
+		}
+	}
+	return r, nil
+}
+
+// invalidSession reports whether the server no longer has the session the
+// command was sent in.
+func (r *reply) invalidSession() bool {
+	var message string
+	json.Unmarshal(r.body["error"], &message)
+	return r.status == http.StatusBadRequest && message == errInvalidSession
+}
@@ -0,0 +1,53 @@
+package main
+
+import "strings"
+
+// commands are the names offered by tab completion, including the ones the
+// REPL handles itself.
+var commands = []string{
+	"SET", "GET", "DEL", "MGET", "MSET", "MSETNX", "SCAN", "KEYS",
+	"QPUSH", "QPOP", "BQPOP", "PUBLISH",
+	"MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH", "CONFIG",
+	"SHUTDOWN", "INFO", "SLOWLOG", "ACL",
+	"HELP", "QUIT",
+}
+
+// complete is the terminal's AutoCompleteCallback. On tab it completes the
+// command name under the cursor as far as the candidates agree, followed by
+// a space once only one is left. Lower case input is completed in lower case.
+func complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	word := line[:pos]
+	if strings.ContainsAny(word, " \t") {
+		return "", 0, false // only the first word is a command name
+	}
+
+	var candidates []string
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd, strings.ToUpper(word)) {
+			candidates = append(candidates, cmd)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+
+	prefix := candidates[0]
+	for _, cmd := range candidates[1:] {
+		for !strings.HasPrefix(cmd, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+	if word != "" && word == strings.ToLower(word) {
+		prefix = strings.ToLower(prefix)
+	}
+	if prefix == word {
+		return "", 0, false
+	}
+	return prefix + line[pos:], len(prefix), true
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SinisterSup/kv-datastore/client"
+)
+
+// printer renders command replies, either pretty-printed for people, in the
+// style of redis-cli, or raw for scripts.
+type printer struct {
+	out io.Writer
+	raw bool
+}
+
+// print renders the reply to one command.
+func (p printer) print(body map[string]json.RawMessage, err error) {
+	if p.raw {
+		p.printRaw(body, err)
+		return
+	}
+	for _, line := range pretty(body, err) {
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// pretty renders a reply as lines of text. Missing keys and unmet conditions
+// show as (nil), errors as (error).
+func pretty(body map[string]json.RawMessage, err error) []string {
+	var serverErr *client.Error
+	switch {
+	case errors.Is(err, client.ErrNotFound), errors.Is(err, client.ErrConditionNotMet):
+		return []string{"(nil)"}
+	case errors.As(err, &serverErr):
+		return []string{"(error) " + serverErr.Message}
+	case err != nil:
+		return []string{"(error) " + err.Error()}
+	}
+
+	if raw, ok := body["results"]; ok {
+		var results []map[string]json.RawMessage
+		json.Unmarshal(raw, &results)
+		if len(results) == 0 {
+			return []string{"(empty array)"}
+		}
+		var lines []string
+		for i, result := range results {
+			lines = append(lines, numbered(i, len(results), pretty(result, resultErr(result)))...)
+		}
+		return lines
+	}
+	if raw, ok := body["keys"]; ok {
+		var keys []string
+		json.Unmarshal(raw, &keys)
+		list := quotedList(keys)
+		if raw, ok := body["cursor"]; ok {
+			var cursor string
+			json.Unmarshal(raw, &cursor)
+			return append(numbered(0, 2, []string{strconv.Quote(cursor)}), numbered(1, 2, list)...)
+		}
+		return list
+	}
+	if raw, ok := body["values"]; ok {
+		var values []*string
+		json.Unmarshal(raw, &values)
+		lines := make([]string, len(values))
+		for i, v := range values {
+			lines[i] = "(nil)"
+			if v != nil {
+				lines[i] = strconv.Quote(*v)
+			}
+		}
+		return listOf(lines)
+	}
+	if raw, ok := body["value"]; ok {
+		var value string
+		json.Unmarshal(raw, &value)
+		if value == "" {
+			return []string{"(nil)"} // BQPOP timed out
+		}
+		return []string{strconv.Quote(value)}
+	}
+	if raw, ok := body["info"]; ok {
+		return infoLines(raw)
+	}
+	if raw, ok := body["entries"]; ok {
+		return slowlogLines(raw)
+	}
+	if raw, ok := body["users"]; ok {
+		var users []string
+		json.Unmarshal(raw, &users)
+		return quotedList(users)
+	}
+	if raw, ok := body["acl"]; ok {
+		return aclLines(raw)
+	}
+	if raw, ok := body["user"]; ok {
+		var user string
+		json.Unmarshal(raw, &user)
+		return []string{strconv.Quote(user)}
+	}
+	for _, field := range []string{"deleted", "receivers", "len"} {
+		if raw, ok := body[field]; ok {
+			return []string{"(integer) " + string(raw)}
+		}
+	}
+	if raw, ok := body["message"]; ok {
+		var message string
+		json.Unmarshal(raw, &message)
+		return []string{message}
+	}
+	return []string{"OK"}
+}
+
+// slowlogLines renders the entries of SLOWLOG GET, one per line.
+func slowlogLines(raw json.RawMessage) []string {
+	var entries []struct {
+		ID       uint64   `json:"id"`
+		Time     string   `json:"time"`
+		Duration int64    `json:"duration_us"`
+		Command  []string `json:"command"`
+		Client   string   `json:"client"`
+	}
+	json.Unmarshal(raw, &entries)
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = fmt.Sprintf("#%d %s %dus %s %s", e.ID, e.Time, e.Duration, e.Client, strings.Join(quotedWords(e.Command), " "))
+	}
+	return listOf(lines)
+}
+
+// aclLines renders the reply to ACL GETUSER, one field per line.
+func aclLines(raw json.RawMessage) []string {
+	var acl map[string]json.RawMessage
+	json.Unmarshal(raw, &acl)
+	var lines []string
+	for _, field := range []string{"flags", "passwords", "tokens", "commands", "keys", "channels"} {
+		var words []string
+		if json.Unmarshal(acl[field], &words) == nil {
+			lines = append(lines, field+": "+strings.Join(words, " "))
+			continue
+		}
+		value := string(acl[field])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		lines = append(lines, field+": "+value)
+	}
+	return lines
+}
+
+func quotedWords(words []string) []string {
+	out := make([]string, len(words))
+	for i, w := range words {
+		out[i] = strconv.Quote(w)
+	}
+	return out
+}
+
+// infoLines renders an INFO reply as the server's text format does, with
+// sections and fields in alphabetical order.
+func infoLines(raw json.RawMessage) []string {
+	var sections map[string]map[string]json.RawMessage
+	json.Unmarshal(raw, &sections)
+	var lines []string
+	for _, section := range sortedKeys(sections) {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "# "+strings.ToUpper(section[:1])+section[1:])
+		for _, name := range sortedKeys(sections[section]) {
+			value := string(sections[section][name])
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			lines = append(lines, name+":"+value)
+		}
+	}
+	return lines
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resultErr returns the error carried by one entry of a pipeline or EXEC
+// response, which reports its status in the body.
+func resultErr(result map[string]json.RawMessage) error {
+	var status int
+	json.Unmarshal(result["status"], &status)
+	switch {
+	case status == 404:
+		return client.ErrNotFound
+	case status == 304 || status == 412:
+		return client.ErrConditionNotMet
+	case status >= 400:
+		var message string
+		json.Unmarshal(result["error"], &message)
+		return &client.Error{Status: status, Message: message}
+	}
+	return nil
+}
+
+func quotedList(items []string) []string {
+	return listOf(quotedWords(items))
+}
+
+func listOf(lines []string) []string {
+	if len(lines) == 0 {
+		return []string{"(empty array)"}
+	}
+	var out []string
+	for i, line := range lines {
+		out = append(out, numbered(i, len(lines), []string{line})...)
+	}
+	return out
+}
+
+// numbered prefixes the lines of the i-th of n items with its number,
+// indenting continuation lines to match.
+func numbered(i, n int, lines []string) []string {
+	width := len(strconv.Itoa(n))
+	prefix := fmt.Sprintf("%*d) ", width, i+1)
+	indent := strings.Repeat(" ", len(prefix))
+	out := make([]string, len(lines))
+	for j, line := range lines {
+		if j == 0 {
+			out[j] = prefix + line
+		} else {
+			out[j] = indent + line
+		}
+	}
+	return out
+}
+
+// printRaw writes bare values, one per line, so the output can be piped to
+// other tools. Missing values print as empty lines and errors go to stderr.
+func (p printer) printRaw(body map[string]json.RawMessage, err error) {
+	if err != nil {
+		if !errors.Is(err, client.ErrNotFound) && !errors.Is(err, client.ErrConditionNotMet) {
+			fmt.Fprintln(stderr, err)
+		}
+		fmt.Fprintln(p.out)
+		return
+	}
+
+	if raw, ok := body["info"]; ok {
+		for _, line := range infoLines(raw) {
+			fmt.Fprintln(p.out, line)
+		}
+		return
+	}
+	if raw, ok := body["acl"]; ok {
+		for _, line := range aclLines(raw) {
+			fmt.Fprintln(p.out, line)
+		}
+		return
+	}
+	for _, field := range []string{"value", "values", "keys", "deleted", "receivers", "len", "entries", "users", "user", "message", "results"} {
+		raw, ok := body[field]
+		if !ok {
+			continue
+		}
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			items = []json.RawMessage{raw}
+		}
+		for _, item := range items {
+			var s string
+			switch {
+			case json.Unmarshal(item, &s) == nil:
+				fmt.Fprintln(p.out, s)
+			case string(item) == "null":
+				fmt.Fprintln(p.out)
+			default:
+				fmt.Fprintln(p.out, string(item))
+			}
+		}
+		return
+	}
+}
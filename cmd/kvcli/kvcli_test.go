@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/client"
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/SinisterSup/kv-datastore/server"
+	"github.com/gin-gonic/gin"
+)
+
+func newCLI(t *testing.T, raw bool) (*cli, *bytes.Buffer) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ts := httptest.NewServer(server.New(kvs.NewKeyValueStore()))
+	t.Cleanup(ts.Close)
+
+	var out bytes.Buffer
+	stderr = &out
+	c := &cli{client: client.New(ts.URL), timeout: 5 * time.Second, printer: printer{out: &out, raw: raw}}
+	return c, &out
+}
+
+func TestScript(t *testing.T) {
+	c, out := newCLI(t, false)
+
+	script := strings.Join([]string{
+		"set greeting hello",
+		"get greeting",
+		"GET missing",
+		"MSET a 1 b 2",
+		"MGET a missing b",
+		"DEL a b",
+		"KEYS greet*",
+		"NOPE",
+	}, "\n")
+	if err := c.script(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"value set for key: greeting",
+		`"hello"`,
+		"(nil)",
+		"values set for 2 keys",
+		`1) "1"`,
+		`2) (nil)`,
+		`3) "2"`,
+		"(integer) 2",
+		`1) "greeting"`,
+		"(error) invalid command",
+		"",
+	}, "\n")
+	if out.String() != expected {
+		t.Errorf("script FAILED: expected\n%v\nbut got\n%v", expected, out.String())
+	}
+	if !c.failed {
+		t.Errorf("script FAILED: expected the invalid command to fail the script")
+	}
+}
+
+func TestRaw(t *testing.T) {
+	c, out := newCLI(t, true)
+
+	for _, line := range []string{"SET k v", "GET k", "MGET k missing", "QPOP missing"} {
+		if err := c.run(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "value set for key: k\nv\nv\n\n\n"
+	if out.String() != expected {
+		t.Errorf("raw FAILED: expected %q, but got %q", expected, out.String())
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	c, out := newCLI(t, true)
+
+	for _, line := range []string{"MULTI", "SET k v", "GET k", "EXEC", "GET k"} {
+		if err := c.run(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) < 3 || lines[1] != "QUEUED" || lines[2] != "QUEUED" || lines[len(lines)-1] != "v" {
+		t.Errorf("transaction FAILED: expected queued commands run by EXEC, but got %q", out.String())
+	}
+	if c.failed {
+		t.Errorf("transaction FAILED: expected no command to fail, but got %q", out.String())
+	}
+}
+
+func TestPipe(t *testing.T) {
+	c, out := newCLI(t, false)
+
+	var input strings.Builder
+	for i := 0; i < pipeBatch+5; i++ {
+		input.WriteString("SET key value\n")
+	}
+	input.WriteString("QPOP missing\n")
+	if err := c.pipe(strings.NewReader(input.String()), out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "All data transferred. errors: 1, replies: 1006\n") {
+		t.Errorf("pipe FAILED: got %q", out.String())
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		line, expected string
+	}{
+		{"GE", "GET "},
+		{"ms", "mset"},
+		{"msetn", "msetnx "},
+		{"Q", "Q"},
+		{"QP", "QP"},
+		{"XYZ", "XYZ"},
+		{"SET ke", "SET ke"},
+	}
+	for _, test := range tests {
+		line, _, ok := complete(test.line, len(test.line), '\t')
+		if !ok {
+			line = test.line
+		}
+		if line != test.expected {
+			t.Errorf("complete(%q) FAILED: expected %q, but got %q", test.line, test.expected, line)
+		}
+	}
+}
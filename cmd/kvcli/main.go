@@ -0,0 +1,261 @@
+// Command kvcli is an interactive client for the store.
+//
+//	kvcli                       start a REPL
+//	kvcli GET greeting          run one command and exit
+//	kvcli < commands.txt        run one command per line of stdin
+//	kvcli --pipe < data.txt     bulk load commands from stdin in pipelines
+//
+// --raw prints bare values instead of the decorated output, for scripts.
+// --cacert, --cert and --key set up TLS for an https:// --addr.
+// Credentials are taken from the environment, so that they do not show up
+// in the process list: an API token from $KVCLI_TOKEN, or the password of
+// --user from $KVCLI_PASSWORD.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/client"
+	"golang.org/x/term"
+)
+
+var stderr io.Writer = os.Stderr
+
+// pipeBatch is how many commands --pipe sends per request.
+const pipeBatch = 1000
+
+func main() {
+	addr := flag.String("addr", envOr("KVCLI_ADDR", "http://localhost:8080"), "server URL, also read from $KVCLI_ADDR")
+	raw := flag.Bool("raw", false, "print bare values without decoration")
+	pipe := flag.Bool("pipe", false, "bulk load the commands read from stdin")
+	timeout := flag.Duration("timeout", 30*time.Second, "deadline for each request")
+	user := flag.String("user", os.Getenv("KVCLI_USER"), "user to authenticate as with $KVCLI_PASSWORD, also read from $KVCLI_USER")
+	cacert := flag.String("cacert", "", "PEM CA to verify an https:// server with, instead of the system's")
+	cert := flag.String("cert", "", "PEM client certificate, for servers requiring mutual TLS")
+	key := flag.String("key", "", "PEM private key of --cert")
+	flag.Parse()
+
+	var opts []client.Option
+	if *cacert != "" || *cert != "" {
+		config, err := tlsConfig(*cacert, *cert, *key)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			os.Exit(1)
+		}
+		opts = append(opts, client.WithTLSConfig(config))
+	}
+	switch {
+	case os.Getenv("KVCLI_TOKEN") != "":
+		opts = append(opts, client.WithToken(os.Getenv("KVCLI_TOKEN")))
+	case *user != "":
+		opts = append(opts, client.WithBasicAuth(*user, os.Getenv("KVCLI_PASSWORD")))
+	}
+	c := client.New(*addr, opts...)
+	defer c.Close()
+	cli := &cli{client: c, timeout: *timeout}
+
+	var err error
+	switch {
+	case flag.NArg() > 0:
+		cli.printer = printer{out: os.Stdout, raw: *raw}
+		err = cli.run(strings.Join(flag.Args(), " "))
+	case *pipe:
+		err = cli.pipe(os.Stdin, os.Stdout)
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		cli.printer = printer{out: os.Stdout, raw: *raw}
+		err = cli.repl()
+	default:
+		cli.printer = printer{out: os.Stdout, raw: *raw}
+		err = cli.script(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		os.Exit(1)
+	}
+	if cli.failed && !cli.interactive {
+		os.Exit(1)
+	}
+}
+
+// tlsConfig trusts the CA in the file at cacert, if given, and presents the
+// client certificate in cert and key, if given.
+func tlsConfig(cacert, cert, key string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cacert != "" {
+		pem, err := os.ReadFile(cacert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", cacert)
+		}
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+type cli struct {
+	client  *client.Client
+	session *client.Session // what the commands are sent in, so MULTI and AUTH apply to the later ones
+	printer printer
+	timeout time.Duration
+	failed  bool // a command failed, which fails scripts
+
+	interactive bool
+}
+
+// run executes one command line and prints its reply. It only fails on
+// errors that are not the command's own, such as an unreachable server.
+func (c *cli) run(line string) error {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return nil
+	}
+	words[0] = strings.ToUpper(words[0])
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if c.session == nil {
+		c.session = c.client.NewSession()
+	}
+	body, err := c.session.Do(ctx, words...)
+	if err != nil && body == nil {
+		return err
+	}
+	if err != nil {
+		c.failed = true
+	}
+	c.printer.print(body, err)
+	return nil
+}
+
+// script runs one command per line of r.
+func (c *cli) script(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := c.run(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// pipe sends the commands read from r in pipelines of pipeBatch commands
+// and reports how many failed, like redis-cli --pipe.
+func (c *cli) pipe(r io.Reader, w io.Writer) error {
+	var errs, replies int
+	p := c.client.Pipeline()
+	var pending []*client.Reply
+
+	flush := func() error {
+		if p.Len() == 0 {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		if err := p.Exec(ctx); err != nil {
+			return err
+		}
+		for _, reply := range pending {
+			replies++
+			if err := reply.Err(); err != nil && err != client.ErrConditionNotMet {
+				errs++
+				fmt.Fprintln(stderr, err)
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+		words[0] = strings.ToUpper(words[0])
+		pending = append(pending, p.Do(words...))
+		if p.Len() == pipeBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "All data transferred. errors: %d, replies: %d\n", errs, replies)
+	c.failed = errs > 0
+	return nil
+}
+
+// repl reads commands from the terminal until EOF or QUIT, with line
+// editing, history on the arrow keys and tab completion of command names.
+func (c *cli) repl() error {
+	c.interactive = true
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+
+	screen := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	t := term.NewTerminal(screen, "kvs> ")
+	t.AutoCompleteCallback = complete
+	if width, height, err := term.GetSize(int(os.Stdin.Fd())); err == nil {
+		t.SetSize(width, height)
+	}
+	c.printer.out = t
+	stderr = t
+
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToUpper(strings.TrimSpace(line)) {
+		case "QUIT", "EXIT":
+			return nil
+		case "HELP":
+			fmt.Fprintln(t, "Commands: "+strings.Join(commands, " "))
+			continue
+		}
+		if err := c.run(line); err != nil {
+			fmt.Fprintln(t, "(error) "+err.Error())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Command kvserver serves the store over the REST API.
+//
+// Settings are read from the defaults, then the YAML file given by -config
+// or $KVS_CONFIG, then KVS_* environment variables, then flags; see the
+// config package.
+//
+// SIGINT and SIGTERM shut the server down gracefully, as SHUTDOWN does.
+// SIGHUP reloads the auth file and the TLS files, to rotate credentials.
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/SinisterSup/kv-datastore/config"
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/SinisterSup/kv-datastore/server"
+	"github.com/gin-gonic/gin"
+)
+
+// runtimeSettings can be changed with CONFIG SET; the rest of the settings
+// only take effect on restart.
+var runtimeSettings = []string{
+	"loglevel", "maxmemory-policy", "maxmemory", "notify-keyspace-events",
+	"default-ttl", "queue-ttl", "queue-buffer", "subscriber-buffer",
+	"slowlog-threshold", "slowlog-max-len", "shutdown-timeout",
+	"audit-redact", "ratelimit", "ratelimit-read", "ratelimit-write",
+	"ratelimit-queue", "ratelimit-admin", "tenant-quotas",
+}
+
+func main() {
+	cfg := config.Default()
+	configPath := flag.String("config", os.Getenv("KVS_CONFIG"), "YAML file to read the settings from")
+	flags := cfg.Flags(flag.CommandLine)
+	flag.Parse()
+
+	if *configPath != "" {
+		if err := cfg.LoadFile(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if err := flags.Apply(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.LogLevel == "debug" {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	myStore := kvs.NewShardedStore(cfg.Shards)
+	if cfg.CDCRetention > 0 {
+		myStore.EnableChangeLog(cfg.CDCRetention)
+	}
+
+	srv := server.New(myStore)
+	if err := srv.SetLogOutput(os.Stderr, cfg.LogFormat); err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range runtimeSettings {
+		value, _ := cfg.Get(name)
+		if err := srv.SetConfig(name, value); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+	if err := srv.SetAuthFile(cfg.AuthFile); err != nil {
+		log.Fatalf("auth-file: %v", err)
+	}
+	srv.SetSnapshotPath(cfg.Snapshot)
+	srv.RegisterConfig("listen", func() string { return cfg.Listen }, nil)
+	srv.RegisterConfig("shards", func() string { return strconv.Itoa(cfg.Shards) }, nil)
+	srv.RegisterConfig("cdc-retention", func() string { return strconv.Itoa(cfg.CDCRetention) }, nil)
+	srv.RegisterConfig("log-format", func() string { return cfg.LogFormat }, nil)
+	srv.RegisterConfig("audit-log", func() string { return cfg.AuditLog }, nil)
+	srv.RegisterConfig("tls-cert-file", func() string { return cfg.TLSCertFile }, nil)
+	srv.RegisterConfig("tls-key-file", func() string { return cfg.TLSKeyFile }, nil)
+	srv.RegisterConfig("tls-ca-cert-file", func() string { return cfg.TLSCACertFile }, nil)
+	srv.RegisterConfig("tls-auth-clients", func() string { return cfg.TLSAuthClients }, nil)
+	logger := srv.Logger()
+
+	if cfg.TLSCertFile != "" {
+		if err := srv.SetTLS(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCACertFile, cfg.TLSAuthClients); err != nil {
+			log.Fatalf("tls: %v", err)
+		}
+	}
+	if cfg.AuditLog != "" {
+		audit, err := openLog(cfg.AuditLog)
+		if err != nil {
+			log.Fatalf("audit log: %v", err)
+		}
+		defer audit.Close()
+		srv.SetAuditLog(audit)
+	}
+	// The snapshot loads while the server is already up, so that /readyz can
+	// report it; commands are refused until it is done.
+	var loadFailed atomic.Bool
+	if cfg.Snapshot != "" {
+		loaded := srv.LoadSnapshot(cfg.Snapshot)
+		go func() {
+			err := <-loaded
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logger.Error("loading snapshot failed", "path", cfg.Snapshot, "err", err)
+				loadFailed.Store(true)
+				srv.RequestShutdown(server.ShutdownNoSave)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			logger.Info("signal received", "signal", sig.String())
+			if sig != syscall.SIGHUP {
+				srv.RequestShutdown(server.ShutdownDefault)
+				return
+			}
+			if err := srv.ReloadAuth(); err != nil {
+				logger.Error("reloading the auth file failed", "err", err)
+			}
+			if err := srv.ReloadTLS(); err != nil {
+				logger.Error("reloading the TLS files failed", "err", err)
+			}
+		}
+	}()
+
+	logger.Info("starting server", "listen", cfg.Listen, "tls", cfg.TLSCertFile != "")
+	if err := srv.Run(cfg.Listen); err != nil {
+		logger.Error("server stopped", "err", err)
+		os.Exit(1)
+	}
+	if loadFailed.Load() {
+		os.Exit(1)
+	}
+}
+
+// openLog opens the file at path for appending, or stdout or stderr.
+func openLog(path string) (*os.File, error) {
+	switch path {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+}
@@ -0,0 +1,214 @@
+// Package config holds the settings of the server binary. They are read, in
+// increasing order of precedence, from the defaults, a YAML file, KVS_*
+// environment variables and command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/SinisterSup/kv-datastore/server"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variable of every setting, named after
+// its YAML key in upper case with dashes replaced by underscores, e.g.
+// KVS_MAXMEMORY_POLICY.
+const EnvPrefix = "KVS_"
+
+// Config is the settings of the server. The YAML keys double as flag names
+// and as CONFIG GET/SET parameter names for the settings that can change at
+// runtime.
+type Config struct {
+	Listen      string `yaml:"listen" usage:"address to serve the REST API on"`
+	LogLevel    string `yaml:"loglevel" usage:"debug, info, warn or error"`
+	LogFormat   string `yaml:"log-format" usage:"json or text"`
+	AuditLog    string `yaml:"audit-log" usage:"file to record every mutating command to, stdout or stderr, empty to disable"`
+	AuditRedact string `yaml:"audit-redact" usage:"comma-separated globs of the keys whose values the audit log redacts"`
+
+	AuthFile string `yaml:"auth-file" usage:"YAML file of the users clients must authenticate as, empty to let anyone in"`
+
+	TLSCertFile    string `yaml:"tls-cert-file" usage:"PEM certificate to serve TLS with, empty for plain HTTP"`
+	TLSKeyFile     string `yaml:"tls-key-file" usage:"PEM private key of tls-cert-file"`
+	TLSCACertFile  string `yaml:"tls-ca-cert-file" usage:"PEM CAs client certificates are verified against, empty to not ask for them"`
+	TLSAuthClients string `yaml:"tls-auth-clients" usage:"yes, optional or no: whether clients must present a certificate"`
+
+	RateLimit      string `yaml:"ratelimit" usage:"commands each client may run, such as 100/s or 1000/m:50 with a burst, 0 for no limit"`
+	RateLimitRead  string `yaml:"ratelimit-read" usage:"rate limit of the read commands of each client, 0 for none"`
+	RateLimitWrite string `yaml:"ratelimit-write" usage:"rate limit of the write commands of each client, 0 for none"`
+	RateLimitQueue string `yaml:"ratelimit-queue" usage:"rate limit of the queue commands of each client, 0 for none"`
+	RateLimitAdmin string `yaml:"ratelimit-admin" usage:"rate limit of the admin commands of each client, 0 for none"`
+	TenantQuotas   string `yaml:"tenant-quotas" usage:"comma-separated <prefix>=<keys>/<memory> quotas, such as billing:=1000/64mb, 0 for no limit"`
+
+	Shards               int    `yaml:"shards" usage:"number of independently locked shards the keyspace is split into"`
+	MaxMemory            string `yaml:"maxmemory" usage:"memory limit such as 512mb, 0 for no limit"`
+	MaxMemoryPolicy      string `yaml:"maxmemory-policy" usage:"what to evict once maxmemory is reached"`
+	NotifyKeyspaceEvents string `yaml:"notify-keyspace-events" usage:"keyspace notifications to publish, e.g. KEA (see README)"`
+	CDCRetention         int    `yaml:"cdc-retention" usage:"number of changes kept for the /changes feed, 0 disables it"`
+
+	DefaultTTL       time.Duration `yaml:"default-ttl" usage:"expiration of keys SET without EX, 0 for none"`
+	QueueTTL         time.Duration `yaml:"queue-ttl" usage:"expiration of each value pushed to a queue, 0 for none"`
+	QueueBuffer      int           `yaml:"queue-buffer" usage:"channel capacity of each key, for blocking pops"`
+	SubscriberBuffer int           `yaml:"subscriber-buffer" usage:"messages buffered per subscriber before it is dropped as too slow"`
+
+	SlowlogThreshold time.Duration `yaml:"slowlog-threshold" usage:"commands running at least this long are kept in the slow log, negative to disable"`
+	SlowlogMaxLen    int           `yaml:"slowlog-max-len" usage:"number of commands kept in the slow log"`
+
+	Snapshot        string        `yaml:"snapshot" usage:"file the keyspace is loaded from at startup and saved to on shutdown, empty for none"`
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout" usage:"how long a shutdown waits for in-flight commands"`
+}
+
+// Default returns the settings used when nothing else is configured.
+func Default() *Config {
+	return &Config{
+		Listen:           ":8080",
+		LogLevel:         "info",
+		LogFormat:        "json",
+		AuditRedact:      server.DefaultAuditRedact,
+		TLSAuthClients:   server.TLSAuthClientsYes,
+		RateLimit:        "0",
+		RateLimitRead:    "0",
+		RateLimitWrite:   "0",
+		RateLimitQueue:   "0",
+		RateLimitAdmin:   "0",
+		Shards:           kvs.DefaultShards,
+		MaxMemory:        "0",
+		MaxMemoryPolicy:  kvs.PolicyNoEviction,
+		CDCRetention:     0, // the feed holds values outside maxmemory
+		DefaultTTL:       kvs.DefaultSetTTL,
+		QueueTTL:         kvs.DefaultQueueTTL,
+		QueueBuffer:      kvs.DefaultQueueBuffer,
+		SubscriberBuffer: server.DefaultSubscriberBuffer,
+		SlowlogThreshold: server.DefaultSlowlogThreshold,
+		SlowlogMaxLen:    server.DefaultSlowlogMaxLen,
+		ShutdownTimeout:  server.DefaultShutdownTimeout,
+	}
+}
+
+// LoadFile reads the settings present in the YAML file at path over c.
+// Unknown keys are an error, to catch typos.
+func (c *Config) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadEnv reads the settings given by environment variables over c.
+func (c *Config) LoadEnv() error {
+	for _, name := range c.Names() {
+		env := EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok {
+			if err := c.Set(name, v); err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Names returns the names of all settings.
+func (c *Config) Names() []string {
+	t := reflect.TypeOf(*c)
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = t.Field(i).Tag.Get("yaml")
+	}
+	return names
+}
+
+// field returns the setting called name along with its usage text.
+func (c *Config) field(name string) (reflect.Value, string, bool) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Type().Field(i); f.Tag.Get("yaml") == name {
+			return v.Field(i), f.Tag.Get("usage"), true
+		}
+	}
+	return reflect.Value{}, "", false
+}
+
+// Set parses value into the setting called name.
+func (c *Config) Set(name, value string) error {
+	f, _, ok := c.field(name)
+	if !ok {
+		return errors.New("unknown setting: " + name)
+	}
+
+	switch f.Interface().(type) {
+	case string:
+		f.SetString(value)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %q", name, value)
+		}
+		f.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %q", name, value)
+		}
+		f.SetInt(int64(d))
+	}
+	return nil
+}
+
+// Get returns the setting called name as text.
+func (c *Config) Get(name string) (string, bool) {
+	f, _, ok := c.field(name)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(f.Interface()), true
+}
+
+// Flags defines a flag for every setting on fs. Flags only record the values
+// given; Apply sets them, so that they can be applied after the file and the
+// environment have been read.
+func (c *Config) Flags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{}
+	for _, name := range c.Names() {
+		name := name
+		_, usage, _ := c.field(name)
+		def, _ := c.Get(name)
+		fs.Func(name, fmt.Sprintf("%s (default %q)", usage, def), func(v string) error {
+			if err := Default().Set(name, v); err != nil {
+				return err
+			}
+			flags.values = append(flags.values, [2]string{name, v})
+			return nil
+		})
+	}
+	return flags
+}
+
+// Flags are the settings given on the command line.
+type Flags struct {
+	values [][2]string
+}
+
+// Apply sets the settings given as flags on c.
+func (f *Flags) Apply(c *Config) error {
+	for _, v := range f.values {
+		if err := c.Set(v[0], v[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvs.yaml")
+	file := "listen: \":9000\"\nmaxmemory: 100mb\nqueue-ttl: 1h\nshards: 4\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KVS_MAXMEMORY", "200mb")
+	t.Setenv("KVS_MAXMEMORY_POLICY", "allkeys-lru")
+
+	cfg := Default()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := cfg.Flags(fs)
+	if err := fs.Parse([]string{"-maxmemory-policy", "allkeys-lfu", "-default-ttl", "90s"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Listen != ":9000" || cfg.Shards != 4 || cfg.QueueTTL != time.Hour {
+		t.Errorf("LoadFile() FAILED: got %+v", cfg)
+	}
+	if cfg.MaxMemory != "200mb" {
+		t.Errorf("LoadEnv() FAILED: expected the environment to override the file, but got %v", cfg.MaxMemory)
+	}
+	if cfg.MaxMemoryPolicy != "allkeys-lfu" || cfg.DefaultTTL != 90*time.Second {
+		t.Errorf("Apply() FAILED: expected flags to override everything, but got %v, %v", cfg.MaxMemoryPolicy, cfg.DefaultTTL)
+	}
+	if cfg.LogLevel != "info" || cfg.CDCRetention != 0 {
+		t.Errorf("Default() FAILED: expected untouched settings to keep their defaults, but got %+v", cfg)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvs.yaml")
+	if err := os.WriteFile(path, []byte("maxmemroy: 1mb\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Default().LoadFile(path); err == nil {
+		t.Errorf("LoadFile() FAILED: expected an error for an unknown key")
+	}
+
+	t.Setenv("KVS_SHARDS", "many")
+	if err := Default().LoadEnv(); err == nil {
+		t.Errorf("LoadEnv() FAILED: expected an error for an invalid number")
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	Default().Flags(fs)
+	if err := fs.Parse([]string{"-queue-ttl", "forever"}); err == nil {
+		t.Errorf("Flags() FAILED: expected an error for an invalid duration")
+	}
+}
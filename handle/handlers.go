@@ -1,6 +1,7 @@
 package handle
 
 import (
+	"context"
 	"strconv"
 	"strings"
 	"time"
@@ -10,73 +11,166 @@ import (
 )
 
 
+// ErrVersionMismatch is returned by SET ... IFVERSION when the key has been
+// written since the client read it.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// defaultExpiration is the expiration, in seconds, of keys set without EX.
+// It is rounded up, as a TTL below a second would otherwise become 0, which
+// never expires.
+func defaultExpiration(s *kvs.KeyValueStore) int {
+	return int((s.DefaultTTL() + time.Second - 1) / time.Second)
+}
+
 func SetHandler(parts []string, kvs *kvs.KeyValueStore) (string, bool, error) {
+	message, _, done, err := SetWithVersionHandler(parts, kvs)
+	return message, done, err
+}
+
+// SetWithVersionHandler is SetHandler, also returning the version the key was
+// given. It additionally accepts a trailing "IFVERSION <n>", which only sets
+// the key if its current version is n (0 meaning it must not exist).
+func SetWithVersionHandler(parts []string, kvs *kvs.KeyValueStore) (string, uint64, bool, error) {
 	n := len(parts)
 
+	if n >= 4 && strings.EqualFold(parts[n-2], "IFVERSION") {
+		return compareAndSet(parts, kvs)
+	}
+
 	if n < 2 || n > 5 {
-		return "", true, errors.New("invalid number of arguments for set")
+		return "", 0, true, errors.New("invalid number of arguments for set")
 	}
 
 	key, value := parts[0], parts[1]
 
 	switch {
 	case n == 2:
-		kvs.Set(key, value, 99999, "")
+		version, _, err := kvs.SetWithVersion(key, value, defaultExpiration(kvs), "")
+		if err != nil {
+			return "", 0, false, err
+		}
 		returnString := "value set for key: " + key
-		return returnString, true, nil
+		return returnString, version, true, nil
 	case n == 3:
-		hasSet := kvs.Set(key, value, 99999, parts[2])
+		version, hasSet, err := kvs.SetWithVersion(key, value, defaultExpiration(kvs), parts[2])
+		if err != nil {
+			return "", 0, false, err
+		}
 		if hasSet {
 			returnString := "value set for key: " + key
-			return returnString, true, nil
+			return returnString, version, true, nil
 		}
 		returnString := "Already satisfies condition for NX or XX"
-		return returnString, false, nil
+		return returnString, 0, false, nil
 		
 	case n == 4:
 		if strings.EqualFold(parts[2], "EX") {
 			timeInt, err := strconv.Atoi(parts[3]) // Integer value of time
 			if err != nil {
-				return "", false, errors.New("invalid time")
+				return "", 0, false, errors.New("invalid time")
+			}
+			version, _, err := kvs.SetWithVersion(key, value, timeInt, "")
+			if err != nil {
+				return "", 0, false, err
 			}
-			kvs.Set(key, value, timeInt, "")
 			returnString := "value set for key: " + key
-			return returnString, true, nil
+			return returnString, version, true, nil
 		} 
-		return "", false, errors.New("invalid command")
+		return "", 0, false, errors.New("invalid command")
 		
 	case n == 5:
 		if strings.EqualFold(parts[2], "EX") {
 			timeInt, err := strconv.Atoi(parts[3]) // Integer value of time
 			if err != nil {
-				return "", false, errors.New("invalid time")
+				return "", 0, false, errors.New("invalid time")
 			} 
-			hasSet := kvs.Set(key, value, timeInt, parts[4])
+			version, hasSet, err := kvs.SetWithVersion(key, value, timeInt, parts[4])
+			if err != nil {
+				return "", 0, false, err
+			}
 			if hasSet {
 				returnString := "value set for key: " + key
-				return returnString, true, nil
+				return returnString, version, true, nil
 			} 
 			returnString := "Already satisfies condition for NX or XX"
-			return returnString, false, nil 
+			return returnString, 0, false, nil 
+		}
+		return "", 0, false, errors.New("invalid command")
+	}
+	return "", 0, false, errors.New("invalid command")
+}
+
+// compareAndSet handles SET <key> <value> [EX <seconds>] IFVERSION <n>.
+// NX and XX are refused, as the version already says whether the key must
+// exist.
+func compareAndSet(parts []string, kvs *kvs.KeyValueStore) (string, uint64, bool, error) {
+	n := len(parts)
+	key, value := parts[0], parts[1]
+
+	version, err := strconv.ParseUint(parts[n-1], 10, 64)
+	if err != nil {
+		return "", 0, false, errors.New("invalid version")
+	}
+
+	timeInt := defaultExpiration(kvs)
+	for options := parts[2 : n-2]; len(options) > 0; {
+		switch strings.ToUpper(options[0]) {
+		case "EX":
+			if len(options) < 2 {
+				return "", 0, true, errors.New("invalid number of arguments for set")
+			}
+			timeInt, err = strconv.Atoi(options[1]) // Integer value of time
+			if err != nil {
+				return "", 0, false, errors.New("invalid time")
+			}
+			options = options[2:]
+		case "NX", "XX":
+			return "", 0, false, errors.New("IFVERSION can not be combined with NX or XX")
+		default:
+			return "", 0, false, errors.New("invalid command")
 		}
-		return "", false, errors.New("invalid command")
 	}
-	return "", false, errors.New("invalid command")
+
+	current, hasSet, err := kvs.CompareAndSet(key, value, timeInt, version)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if !hasSet {
+		return "", current, false, ErrVersionMismatch
+	}
+	returnString := "value set for key: " + key
+	return returnString, current, true, nil
 }
 
 func GetHandler(parts []string, kvs *kvs.KeyValueStore) (string, bool, error) {
+	val, _, done, err := GetWithVersionHandler(parts, kvs)
+	return val, done, err
+}
+
+// GetWithVersionHandler is GetHandler, also returning the key's version.
+func GetWithVersionHandler(parts []string, kvs *kvs.KeyValueStore) (string, uint64, bool, error) {
 	n := len(parts)
 
 	if n != 1 {
-		return "", true, errors.New("invalid number of arguments for get")
+		return "", 0, true, errors.New("invalid number of arguments for get")
 	}
 
 	key := parts[0]
-	val, ok := kvs.Get(key)
+	val, version, ok := kvs.GetWithVersion(key)
 	if !ok {
-		return "", false, errors.New("key not found")
+		return "", 0, false, errors.New("key not found")
 	} 
-	return val, true, nil
+	return val, version, true, nil
+}
+
+func DelHandler(parts []string, kvs *kvs.KeyValueStore) (int, bool, error) {
+	n := len(parts)
+
+	if n < 1 {
+		return 0, true, errors.New("invalid number of arguments for del")
+	}
+
+	return kvs.Del(parts), true, nil
 }
 
 func QpushHandler(parts []string, kvs *kvs.KeyValueStore) (string, bool, error) {
@@ -111,6 +205,12 @@ func QpopHandler(parts []string, kvs *kvs.KeyValueStore) (string, bool, error) {
 }
 
 func BqpopHandler(parts []string, kvs *kvs.KeyValueStore) (string, bool, error) {
+	return BqpopContextHandler(context.Background(), parts, kvs)
+}
+
+// BqpopContextHandler is BqpopHandler giving up early, with ctx's error,
+// once ctx is done.
+func BqpopContextHandler(ctx context.Context, parts []string, kvs *kvs.KeyValueStore) (string, bool, error) {
   n := len(parts)
 
   if n != 2 {
@@ -124,7 +224,10 @@ func BqpopHandler(parts []string, kvs *kvs.KeyValueStore) (string, bool, error)
   }
   timeout := convFloatToTime(t)
   
-  val := kvs.Bqpop(key, timeout)
+  val, err := kvs.BqpopContext(ctx, key, timeout)
+  if err != nil {
+	return "", false, err
+  }
   return val, true, nil
 }
 
@@ -135,3 +238,121 @@ func convFloatToTime(t1 float64) (time.Duration) {
   // unixTime := time.Unix(0, future.UnixNano())
   // return unixTime
 }
+
+func ScanHandler(parts []string, kvs *kvs.KeyValueStore) (string, []string, bool, error) {
+	n := len(parts)
+
+	if n < 1 || n%2 != 1 {
+		return "", nil, true, errors.New("invalid number of arguments for scan")
+	}
+
+	cursor, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", nil, false, errors.New("invalid cursor")
+	}
+
+	pattern, kind := "", ""
+	count := 0
+	for i := 1; i < n; i += 2 {
+		switch {
+		case strings.EqualFold(parts[i], "MATCH"):
+			pattern = parts[i+1]
+		case strings.EqualFold(parts[i], "COUNT"):
+			count, err = strconv.Atoi(parts[i+1])
+			if err != nil || count < 1 {
+				return "", nil, false, errors.New("invalid count")
+			}
+		case strings.EqualFold(parts[i], "TYPE"):
+			kind = strings.ToLower(parts[i+1])
+		default:
+			return "", nil, false, errors.New("invalid command")
+		}
+	}
+
+	next, keys := kvs.Scan(cursor, pattern, count, kind)
+	return strconv.FormatUint(next, 10), keys, true, nil
+}
+
+func KeysHandler(parts []string, kvs *kvs.KeyValueStore) ([]string, bool, error) {
+	n := len(parts)
+
+	if n != 1 {
+		return nil, true, errors.New("invalid number of arguments for keys")
+	}
+
+	return kvs.Keys(parts[0]), true, nil
+}
+
+func MgetHandler(parts []string, kvs *kvs.KeyValueStore) ([]*string, bool, error) {
+	n := len(parts)
+
+	if n < 1 {
+		return nil, true, errors.New("invalid number of arguments for mget")
+	}
+
+	values, found := kvs.Mget(parts)
+	result := make([]*string, n)
+	for i := range values {
+		if found[i] {
+			result[i] = &values[i]
+		}
+	}
+	return result, true, nil
+}
+
+func MsetHandler(parts []string, kvs *kvs.KeyValueStore) (string, bool, error) {
+	keys, values, err := splitPairs(parts, "mset")
+	if err != nil {
+		return "", true, err
+	}
+
+	if err := kvs.Mset(keys, values, defaultExpiration(kvs)); err != nil {
+		return "", false, err
+	}
+	return "values set for " + strconv.Itoa(len(keys)) + " keys", true, nil
+}
+
+func MsetnxHandler(parts []string, kvs *kvs.KeyValueStore) (string, bool, error) {
+	keys, values, err := splitPairs(parts, "msetnx")
+	if err != nil {
+		return "", true, err
+	}
+
+	hasSet, err := kvs.Msetnx(keys, values, defaultExpiration(kvs))
+	if err != nil {
+		return "", false, err
+	}
+	if !hasSet {
+		return "no key was set, one or more keys already exist", false, nil
+	}
+	return "values set for " + strconv.Itoa(len(keys)) + " keys", true, nil
+}
+
+// splitPairs splits "k1 v1 k2 v2 ..." into keys and values.
+func splitPairs(parts []string, command string) ([]string, []string, error) {
+	n := len(parts)
+
+	if n < 2 || n%2 != 0 {
+		return nil, nil, errors.New("invalid number of arguments for " + command)
+	}
+
+	keys := make([]string, 0, n/2)
+	values := make([]string, 0, n/2)
+	for i := 0; i < n; i += 2 {
+		keys = append(keys, parts[i])
+		values = append(values, parts[i+1])
+	}
+	return keys, values, nil
+}
+
+func PublishHandler(parts []string, ps *kvs.PubSub) (int, bool, error) {
+	n := len(parts)
+
+	if n < 2 {
+		return 0, true, errors.New("invalid number of arguments for publish")
+	}
+
+	channel := parts[0]
+	message := strings.Join(parts[1:], " ")
+	return ps.Publish(channel, message), true, nil
+}
@@ -1,434 +1,632 @@
 package handle_test
 
 import (
-    "testing"
 	"errors"
-    // "sync"
+	"strconv"
+	"testing"
+	"time"
+	// "sync"
 
-    "github.com/SinisterSup/kv-datastore/kvs"
-    "github.com/SinisterSup/kv-datastore/handle"
+	"github.com/SinisterSup/kv-datastore/handle"
+	"github.com/SinisterSup/kv-datastore/kvs"
 )
 
 func TestSetHandler(t *testing.T) {
-    kvs := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-    cases := []struct {
-        name     string
-        parts    []string
-        expected string
-        done     bool
-        err      error
-    }{
-        {
-            name:     "Invalid args",
-            parts:    []string{"key1"},
-            expected: "",
-            done:     true,
-            err:      errors.New("invalid number of arguments for set"),
-        },
-        {
-            name:     "2 arguments",
-            parts:    []string{"key1", "value1"},
-            expected: "value set for key: key1",
-            done:     true,
-            err:      nil,
-        },
-        {
-            name:     "3 arguments",
-            parts:    []string{"key2", "value2", "NX"},
-            expected: "value set for key: key2",
-            done:     true,
-            err:      nil,
-        },
-        {
-            name:     "3 arguments",
-            parts:    []string{"key2", "someVal", "NX"},
-            expected: "Already satisfies condition for NX or XX",
-            done:     false,
-            err:      nil,
-        },
-        {
-            name:     "3 arguments",
-            parts:    []string{"key2", "newVal", "XX"},
-            expected: "value set for key: key2",
-            done:     true,
-            err:      nil,
-        },
-        {
-            name:     "3 arguments",
-            parts:    []string{"newKey", "newVal", "XX"},
-            expected: "Already satisfies condition for NX or XX",
-            done:     false,
-            err:      nil,
-        },
-        {
-            name:     "3 arguments",
-            parts:    []string{"key3", "value3", ""},
-            expected: "value set for key: key3",
-            done:     true,
-            err:      nil,
-        },
-        {
-            name:     "4 arguments",
-            parts:    []string{"key4", "value4", "EX", "10"},
-            expected: "value set for key: key4",
-            done:     true,
-            err:      nil,
-        },
-        {
-            name:     "4 arguments",
-            parts:    []string{"key4", "value4", "Hi", "10"},
-            expected: "",
-            done:     false,
-            err:      errors.New("invalid command"),
-        },
-        {
-            name:     "4 arguments",
-            parts:    []string{"key4", "value4", "EX", "A1"},
-            expected: "",
-            done:     false,
-            err:      errors.New("invalid time"),
-        },
-        {
-            name:     "5 arguments",
-            parts:    []string{"key5", "value5", "EX", "10", "NX"},
-            expected: "value set for key: key5",
-            done:     true,
-            err:      nil,
-        },
-        {
-            name:     "5 arguments",
-            parts:    []string{"key5", "value5", "HX", "10", "NX"},
-            expected: "",
-            done:     false,
-            err:      errors.New("invalid command"),
-        },
-        {
-            name:     "5 arguments",
-            parts:    []string{"key5", "value5", "EX", "NA", "NX"},
-            expected: "",
-            done:     false,
-            err:      errors.New("invalid time"),
-        },
-        {
-            name:     "5 arguments",
-            parts:    []string{"key5", "someVal", "EX", "10", "NX"},
-            expected: "Already satisfies condition for NX or XX",
-            done:     false,
-            err:      nil,
-        },
-    }
-
-    for _, c := range cases {
-        t.Run(c.name, func(t *testing.T) {
-            actual, done, err := handle.SetHandler(c.parts, kvs)
+	kvs := kvs.NewKeyValueStore()
+	cases := []struct {
+		name     string
+		parts    []string
+		expected string
+		done     bool
+		err      error
+	}{
+		{
+			name:     "Invalid args",
+			parts:    []string{"key1"},
+			expected: "",
+			done:     true,
+			err:      errors.New("invalid number of arguments for set"),
+		},
+		{
+			name:     "2 arguments",
+			parts:    []string{"key1", "value1"},
+			expected: "value set for key: key1",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "3 arguments",
+			parts:    []string{"key2", "value2", "NX"},
+			expected: "value set for key: key2",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "3 arguments",
+			parts:    []string{"key2", "someVal", "NX"},
+			expected: "Already satisfies condition for NX or XX",
+			done:     false,
+			err:      nil,
+		},
+		{
+			name:     "3 arguments",
+			parts:    []string{"key2", "newVal", "XX"},
+			expected: "value set for key: key2",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "3 arguments",
+			parts:    []string{"newKey", "newVal", "XX"},
+			expected: "Already satisfies condition for NX or XX",
+			done:     false,
+			err:      nil,
+		},
+		{
+			name:     "3 arguments",
+			parts:    []string{"key3", "value3", ""},
+			expected: "value set for key: key3",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "4 arguments",
+			parts:    []string{"key4", "value4", "EX", "10"},
+			expected: "value set for key: key4",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "4 arguments",
+			parts:    []string{"key4", "value4", "Hi", "10"},
+			expected: "",
+			done:     false,
+			err:      errors.New("invalid command"),
+		},
+		{
+			name:     "4 arguments",
+			parts:    []string{"key4", "value4", "EX", "A1"},
+			expected: "",
+			done:     false,
+			err:      errors.New("invalid time"),
+		},
+		{
+			name:     "5 arguments",
+			parts:    []string{"key5", "value5", "EX", "10", "NX"},
+			expected: "value set for key: key5",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "5 arguments",
+			parts:    []string{"key5", "value5", "HX", "10", "NX"},
+			expected: "",
+			done:     false,
+			err:      errors.New("invalid command"),
+		},
+		{
+			name:     "5 arguments",
+			parts:    []string{"key5", "value5", "EX", "NA", "NX"},
+			expected: "",
+			done:     false,
+			err:      errors.New("invalid time"),
+		},
+		{
+			name:     "5 arguments",
+			parts:    []string{"key5", "someVal", "EX", "10", "NX"},
+			expected: "Already satisfies condition for NX or XX",
+			done:     false,
+			err:      nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, done, err := handle.SetHandler(c.parts, kvs)
 			if actual != c.expected || done != c.done || (err != nil && c.err != nil && err.Error() != c.err.Error()) {
 				t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", c.expected, c.done, c.err, actual, done, err)
 			}
-        })
-    }
+		})
+	}
 }
 
 func BenchmarkSetHandler(b *testing.B) {
-    kvs := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-    parts := []string{"benchmark_key", "benchmark_value"}
+	kvs := kvs.NewKeyValueStore()
+	parts := []string{"benchmark_key", "benchmark_value"}
 
-    for i := 0; i < b.N; i++ {
-        _, _, _ = handle.SetHandler(parts, kvs)
-    }
+	for i := 0; i < b.N; i++ {
+		_, _, _ = handle.SetHandler(parts, kvs)
+	}
 }
 
 func TestGetHandler(t *testing.T) {
-    s := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-    s.Set("key", "value", 9999, "")
-    tests := []struct {
-        name     string
-        parts    []string
-        expected string
-        done     bool
-        err      error
-    }{
-        {
-            name: "Invalid args",
-            parts: []string{"key", "value", "NA"},
-            expected: "",
-            done: true,
-            err: errors.New("invalid number of arguments for get"),
-        },
-        {
-            name: "valid Key",
-            parts: []string{"key"},
-            expected: "value",
-            done: true,
-            err: nil,
-        },
-        {
-            name: "Unknown Key",
-            parts: []string{"Unknown"},
-            expected: "",
-            done: false,
-            err: errors.New("key not found"),
-        },
-    }
-    for _, test := range tests {
-        t.Run(test.name, func(t *testing.T) {
-            actual, done, err := handle.GetHandler(test.parts, s)
-            if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
+	s := kvs.NewKeyValueStore()
+	s.Set("key", "value", 9999, "")
+	tests := []struct {
+		name     string
+		parts    []string
+		expected string
+		done     bool
+		err      error
+	}{
+		{
+			name:     "Invalid args",
+			parts:    []string{"key", "value", "NA"},
+			expected: "",
+			done:     true,
+			err:      errors.New("invalid number of arguments for get"),
+		},
+		{
+			name:     "valid Key",
+			parts:    []string{"key"},
+			expected: "value",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "Unknown Key",
+			parts:    []string{"Unknown"},
+			expected: "",
+			done:     false,
+			err:      errors.New("key not found"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, done, err := handle.GetHandler(test.parts, s)
+			if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
 				t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
 			}
-        })
-    }
+		})
+	}
 }
 
 func BenchmarkGetHandler(b *testing.B) {
-    s := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-    s.Set("key", "value", 9999, "")
-    tests := []struct {
-        name     string
-        parts    []string
-        expected string
-        done     bool
-        err      error
-    }{
-        {
-            name: "Invalid args",
-            parts: []string{"key", "value", "NA"},
-            expected: "",
-            done: true,
-            err: errors.New("invalid number of arguments for get"),
-        },
-        {
-            name: "valid Key",
-            parts: []string{"key"},
-            expected: "value",
-            done: true,
-            err: nil,
-        },
-        {
-            name: "Unknown Key",
-            parts: []string{"Unknown"},
-            expected: "",
-            done: false,
-            err: errors.New("key not found"),
-        },
-    }
-    for i := 0; i < b.N; i++ {
-        for _, test := range tests {
-            b.Run(test.name, func(b *testing.B) {
-                actual, done, err := handle.GetHandler(test.parts, s)
-                if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
-                    b.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
-                }
-            })
-        }
-    }
+	s := kvs.NewKeyValueStore()
+	s.Set("key", "value", 9999, "")
+	tests := []struct {
+		name     string
+		parts    []string
+		expected string
+		done     bool
+		err      error
+	}{
+		{
+			name:     "Invalid args",
+			parts:    []string{"key", "value", "NA"},
+			expected: "",
+			done:     true,
+			err:      errors.New("invalid number of arguments for get"),
+		},
+		{
+			name:     "valid Key",
+			parts:    []string{"key"},
+			expected: "value",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "Unknown Key",
+			parts:    []string{"Unknown"},
+			expected: "",
+			done:     false,
+			err:      errors.New("key not found"),
+		},
+	}
+	for i := 0; i < b.N; i++ {
+		for _, test := range tests {
+			b.Run(test.name, func(b *testing.B) {
+				actual, done, err := handle.GetHandler(test.parts, s)
+				if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
+					b.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
+				}
+			})
+		}
+	}
 }
 
 func TestQpushHandler(t *testing.T) {
-    s := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-    tests := []struct {
-        name     string
-        parts    []string
-        expected string
-        done     bool
-        err      error
-    }{
-        {
-            name: "Invalid args",
-            parts: []string{"OnlyKey"},
-            expected: "",
-            done: true,
-            err: errors.New("invalid number of arguments for qpush"),
-        },
-        {
-            name: "Key - Values...",
-            parts: []string{"key", "value1", "value2", "value3"},
-            expected: "values pushed to queue",
-            done: true,
-            err: nil,
-        },
-    }
-    for _, test := range tests {
-        t.Run(test.name, func(t *testing.T) {
-            actual, done, err := handle.QpushHandler(test.parts, s)
-            if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
-                t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
-            }
-        })
-    }
+	s := kvs.NewKeyValueStore()
+	tests := []struct {
+		name     string
+		parts    []string
+		expected string
+		done     bool
+		err      error
+	}{
+		{
+			name:     "Invalid args",
+			parts:    []string{"OnlyKey"},
+			expected: "",
+			done:     true,
+			err:      errors.New("invalid number of arguments for qpush"),
+		},
+		{
+			name:     "Key - Values...",
+			parts:    []string{"key", "value1", "value2", "value3"},
+			expected: "values pushed to queue",
+			done:     true,
+			err:      nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, done, err := handle.QpushHandler(test.parts, s)
+			if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
+				t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
+			}
+		})
+	}
 }
 
 func BenchmarkQpushHandler(b *testing.B) {
-    s := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-    parts := []string{"key", "value1", "value2", "value3"}
-    for i := 0; i < b.N; i++ {
-        _, _, _ = handle.QpushHandler(parts, s)
-    }
+	s := kvs.NewKeyValueStore()
+	parts := []string{"key", "value1", "value2", "value3"}
+	for i := 0; i < b.N; i++ {
+		_, _, _ = handle.QpushHandler(parts, s)
+	}
 }
 
 func TestQpopHandler(t *testing.T) {
-    s := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-
-    someErr := s.Qpush("key", []string{"value1", "value2"})
-    if someErr != nil {
-        t.Errorf("Error while pushing values to queue")
-    }
-
-    tests := []struct {
-        name     string
-        parts    []string
-        expected string
-        done     bool
-        err      error
-    }{
-        {
-            name: "Invalid args",
-            parts: []string{"OnlyKey", "SomeValue"},
-            expected: "",
-            done: true,
-            err: errors.New("invalid number of arguments for qpop"),
-        },
-        {
-            name: "Unknown Key",
-            parts: []string{"Unknown"},
-            expected: "",
-            done: false,
-            err: errors.New("key not found"),
-        },
-        {
-            name: "Valid Key",
-            parts: []string{"key"},
-            expected: "value2",
-            done: true,
-            err: nil,
-        },
-        {
-            name: "Valid Key",
-            parts: []string{"key"},
-            expected: "value1",
-            done: true,
-            err: nil,
-        },
-        {
-            name: "Empty Queue",
-            parts: []string{"key"},
-            expected: "",
-            done: false,
-            err: errors.New("queue is empty"),
-        },
-    }
-
-    for _, test := range tests {
-        t.Run(test.name, func(t *testing.T) {
-            actual, done, err := handle.QpopHandler(test.parts, s)
-            if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
-                t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
-            }
-        })
-    }
+	s := kvs.NewKeyValueStore()
+
+	someErr := s.Qpush("key", []string{"value1", "value2"})
+	if someErr != nil {
+		t.Errorf("Error while pushing values to queue")
+	}
+
+	tests := []struct {
+		name     string
+		parts    []string
+		expected string
+		done     bool
+		err      error
+	}{
+		{
+			name:     "Invalid args",
+			parts:    []string{"OnlyKey", "SomeValue"},
+			expected: "",
+			done:     true,
+			err:      errors.New("invalid number of arguments for qpop"),
+		},
+		{
+			name:     "Unknown Key",
+			parts:    []string{"Unknown"},
+			expected: "",
+			done:     false,
+			err:      errors.New("key not found"),
+		},
+		{
+			name:     "Valid Key",
+			parts:    []string{"key"},
+			expected: "value2",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "Valid Key",
+			parts:    []string{"key"},
+			expected: "value1",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "Empty Queue",
+			parts:    []string{"key"},
+			expected: "",
+			done:     false,
+			err:      errors.New("queue is empty"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, done, err := handle.QpopHandler(test.parts, s)
+			if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
+				t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
+			}
+		})
+	}
 }
 
 func BenchmarkQpopHandler(b *testing.B) {
-    s := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
+	s := kvs.NewKeyValueStore()
 
-    someErr := s.Qpush("key", []string{"value1", "value2"})
-    if someErr != nil {
-        b.Errorf("Error while pushing values to queue")
-    }
+	someErr := s.Qpush("key", []string{"value1", "value2"})
+	if someErr != nil {
+		b.Errorf("Error while pushing values to queue")
+	}
 
-    for i := 0; i < b.N; i++ {
-        _, _, _ = handle.QpopHandler([]string{"key"}, s)
-    }
+	for i := 0; i < b.N; i++ {
+		_, _, _ = handle.QpopHandler([]string{"key"}, s)
+	}
 }
 
 func TestBqpopHandler(t *testing.T) {
-    s := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-    someErr := s.Qpush("key", []string{"value1", "value2"})
-    if someErr != nil {
-        t.Errorf("Error while pushing values to queue")
-    }
-
-    tests := []struct {
-        name     string
-        parts    []string
-        expected string
-        done     bool
-        err      error
-    }{
-        {
-            name: "Invalid args",
-            parts: []string{"OnlyKey"},
-            expected: "",
-            done: true,
-            err: errors.New("invalid number of arguments for bqpop"),
-        },
-        {
-            name: "Invalid timeout",
-            parts: []string{"OnlyKey", "NotANumber"},
-            expected: "",
-            done: false,
-            err: errors.New("invalid timeout request"),
-        },
-        {
-            name: "Unknown Key",
-            parts: []string{"Unknown", "0"},
-            expected: "",
-            done: true,
-            err: nil,
-        },
-        {
-            name: "Valid Key",
-            parts: []string{"key", "0"},
-            expected: "value1",
-            done: true,
-            err: nil,
-        },
-        {
-            name: "Valid Key",
-            parts: []string{"key", "0"},
-            expected: "value2",
-            done: true,
-            err: nil,
-        },
-        {
-            name: "Empty Queue",
-            parts: []string{"key", "0"},
-            expected: "",
-            done: true,
-            err: nil,
-        },
-    }
-
-    for _, test := range tests {
-        t.Run(test.name, func(t *testing.T) {
-            actual, done, err := handle.BqpopHandler(test.parts, s)
-            if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
-                t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
-            }
-        })
-    }
-
-    // var wg sync.WaitGroup
-    // wg.Add(1)
-    // go func() {
-    //     t.Log("Testing Concurrent blocking queue pop")
-    //     actual, done, err := handle.BqpopHandler([]string{"NewKey", "10"}, s)
-    //     someErr := s.Qpush("NewKey", []string{"value1", "value2"})
-    //     if someErr != nil {
-    //         t.Errorf("Error while pushing values to queue")
-    //     }
-    //     if actual != "value1" || done != true || err != nil {
-    //         t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", "value1", true, nil, actual, done, err)
-    //     }
-    //     wg.Done()
-    // }()
-    // wg.Wait()
+	s := kvs.NewKeyValueStore()
+	someErr := s.Qpush("key", []string{"value1", "value2"})
+	if someErr != nil {
+		t.Errorf("Error while pushing values to queue")
+	}
+
+	tests := []struct {
+		name     string
+		parts    []string
+		expected string
+		done     bool
+		err      error
+	}{
+		{
+			name:     "Invalid args",
+			parts:    []string{"OnlyKey"},
+			expected: "",
+			done:     true,
+			err:      errors.New("invalid number of arguments for bqpop"),
+		},
+		{
+			name:     "Invalid timeout",
+			parts:    []string{"OnlyKey", "NotANumber"},
+			expected: "",
+			done:     false,
+			err:      errors.New("invalid timeout request"),
+		},
+		{
+			name:     "Unknown Key",
+			parts:    []string{"Unknown", "0"},
+			expected: "",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "Valid Key",
+			parts:    []string{"key", "0"},
+			expected: "value1",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "Valid Key",
+			parts:    []string{"key", "0"},
+			expected: "value2",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "Empty Queue",
+			parts:    []string{"key", "0"},
+			expected: "",
+			done:     true,
+			err:      nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, done, err := handle.BqpopHandler(test.parts, s)
+			if actual != test.expected || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
+				t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
+			}
+		})
+	}
+
+	// var wg sync.WaitGroup
+	// wg.Add(1)
+	// go func() {
+	//     t.Log("Testing Concurrent blocking queue pop")
+	//     actual, done, err := handle.BqpopHandler([]string{"NewKey", "10"}, s)
+	//     someErr := s.Qpush("NewKey", []string{"value1", "value2"})
+	//     if someErr != nil {
+	//         t.Errorf("Error while pushing values to queue")
+	//     }
+	//     if actual != "value1" || done != true || err != nil {
+	//         t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", "value1", true, nil, actual, done, err)
+	//     }
+	//     wg.Done()
+	// }()
+	// wg.Wait()
 }
 
 func BenchmarkBqpopHandler(b *testing.B) {
-    s := &kvs.KeyValueStore{Store: make(map[string]*kvs.QueueChannel)}
-    s.Qpush("key", []string{"value1", "value2"})
+	s := kvs.NewKeyValueStore()
+	s.Qpush("key", []string{"value1", "value2"})
 
-    for i := 0; i < b.N; i++ {
-        _, _, _ = handle.BqpopHandler([]string{"key", "0"}, s)
-    }
-}
\ No newline at end of file
+	for i := 0; i < b.N; i++ {
+		_, _, _ = handle.BqpopHandler([]string{"key", "0"}, s)
+	}
+}
+
+func TestScanHandler(t *testing.T) {
+	s := kvs.NewKeyValueStore()
+	s.Set("key", "value", 9999, "")
+
+	tests := []struct {
+		name   string
+		parts  []string
+		cursor string
+		keys   int
+		done   bool
+		err    error
+	}{
+		{
+			name:   "Invalid args",
+			parts:  []string{"0", "MATCH"},
+			cursor: "",
+			done:   true,
+			err:    errors.New("invalid number of arguments for scan"),
+		},
+		{
+			name:   "Invalid cursor",
+			parts:  []string{"NotANumber"},
+			cursor: "",
+			done:   false,
+			err:    errors.New("invalid cursor"),
+		},
+		{
+			name:   "Invalid count",
+			parts:  []string{"0", "COUNT", "0"},
+			cursor: "",
+			done:   false,
+			err:    errors.New("invalid count"),
+		},
+		{
+			name:   "Unknown option",
+			parts:  []string{"0", "LIMIT", "10"},
+			cursor: "",
+			done:   false,
+			err:    errors.New("invalid command"),
+		},
+		{
+			name:   "Match",
+			parts:  []string{"0", "MATCH", "k*", "COUNT", "10", "TYPE", "STRING"},
+			cursor: "0",
+			keys:   1,
+			done:   true,
+			err:    nil,
+		},
+		{
+			name:   "Type mismatch",
+			parts:  []string{"0", "TYPE", "queue"},
+			cursor: "0",
+			keys:   0,
+			done:   true,
+			err:    nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cursor, keys, done, err := handle.ScanHandler(test.parts, s)
+			if cursor != test.cursor || len(keys) != test.keys || done != test.done || (err != nil && test.err != nil && err.Error() != test.err.Error()) {
+				t.Errorf("Expected: %v, %v, %v, %v, but Got: %v, %v, %v, %v", test.cursor, test.keys, test.done, test.err, cursor, len(keys), done, err)
+			}
+		})
+	}
+}
+
+func TestBatchHandlers(t *testing.T) {
+	s := kvs.NewKeyValueStore()
+
+	if _, _, err := handle.MsetHandler([]string{"k1", "v1", "k2"}, s); err == nil || err.Error() != "invalid number of arguments for mset" {
+		t.Errorf("Expected: %v, but Got: %v", "invalid number of arguments for mset", err)
+	}
+	if msg, done, err := handle.MsetHandler([]string{"k1", "v1", "k2", "v2"}, s); msg != "values set for 2 keys" || !done || err != nil {
+		t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", "values set for 2 keys", true, nil, msg, done, err)
+	}
+	if _, done, err := handle.MsetnxHandler([]string{"k2", "x", "k3", "y"}, s); done || err != nil {
+		t.Errorf("Expected: %v, %v, but Got: %v, %v", false, nil, done, err)
+	}
+
+	values, done, err := handle.MgetHandler([]string{"k1", "k3", "k2"}, s)
+	if !done || err != nil || len(values) != 3 {
+		t.Fatalf("Expected 3 values, but Got: %v, %v, %v", values, done, err)
+	}
+	if values[0] == nil || *values[0] != "v1" || values[1] != nil || values[2] == nil || *values[2] != "v2" {
+		t.Errorf("Expected: [v1 <nil> v2], but Got: %v", values)
+	}
+	if _, done, err := handle.MgetHandler(nil, s); !done || err == nil {
+		t.Errorf("Expected: %v, %v, but Got: %v, %v", true, "invalid number of arguments for mget", done, err)
+	}
+}
+
+func TestSetIfVersionHandler(t *testing.T) {
+	s := kvs.NewKeyValueStore()
+	_, version, _, _ := handle.SetWithVersionHandler([]string{"key", "value"}, s)
+	stale := strconv.FormatUint(version-1, 10)
+	current := strconv.FormatUint(version, 10)
+
+	tests := []struct {
+		name     string
+		parts    []string
+		expected string
+		done     bool
+		err      error
+	}{
+		{
+			name:     "Invalid args",
+			parts:    []string{"key", "value", "EX", "IFVERSION", "1"},
+			expected: "",
+			done:     true,
+			err:      errors.New("invalid number of arguments for set"),
+		},
+		{
+			name:     "Invalid version",
+			parts:    []string{"key", "value", "IFVERSION", "NA"},
+			expected: "",
+			done:     false,
+			err:      errors.New("invalid version"),
+		},
+		{
+			name:     "Stale version",
+			parts:    []string{"key", "new", "IFVERSION", stale},
+			expected: "",
+			done:     false,
+			err:      handle.ErrVersionMismatch,
+		},
+		{
+			name:     "Current version",
+			parts:    []string{"key", "new", "EX", "10", "IFVERSION", current},
+			expected: "value set for key: key",
+			done:     true,
+			err:      nil,
+		},
+		{
+			name:     "With NX",
+			parts:    []string{"key", "new", "NX", "IFVERSION", current},
+			expected: "",
+			done:     false,
+			err:      errors.New("IFVERSION can not be combined with NX or XX"),
+		},
+		{
+			name:     "With EX and XX",
+			parts:    []string{"key", "new", "EX", "10", "XX", "IFVERSION", current},
+			expected: "",
+			done:     false,
+			err:      errors.New("IFVERSION can not be combined with NX or XX"),
+		},
+		{
+			name:     "Missing key",
+			parts:    []string{"other", "new", "IFVERSION", "0"},
+			expected: "value set for key: other",
+			done:     true,
+			err:      nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, done, err := handle.SetHandler(test.parts, s)
+			if actual != test.expected || done != test.done || (err == nil) != (test.err == nil) || (err != nil && err.Error() != test.err.Error()) {
+				t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", test.expected, test.done, test.err, actual, done, err)
+			}
+		})
+	}
+}
+
+func TestDelHandler(t *testing.T) {
+	s := kvs.NewKeyValueStore()
+	s.Set("key1", "value", 9999, "")
+	s.Qpush("key2", []string{"value"})
+
+	if _, done, err := handle.DelHandler(nil, s); !done || err == nil || err.Error() != "invalid number of arguments for del" {
+		t.Errorf("Expected: %v, %v, but Got: %v, %v", true, "invalid number of arguments for del", done, err)
+	}
+	if deleted, done, err := handle.DelHandler([]string{"key1", "key2", "missing"}, s); deleted != 2 || !done || err != nil {
+		t.Errorf("Expected: %v, %v, %v, but Got: %v, %v, %v", 2, true, nil, deleted, done, err)
+	}
+	if _, ok := s.Get("key1"); ok {
+		t.Errorf("Expected key1 to be deleted")
+	}
+}
+
+func TestSetHandlerSubSecondDefaultTTL(t *testing.T) {
+	s := kvs.NewKeyValueStore()
+	s.SetDefaultTTL(500 * time.Millisecond)
+
+	if _, done, err := handle.SetHandler([]string{"key", "value"}, s); !done || err != nil {
+		t.Fatalf("Expected: %v, %v, but Got: %v, %v", true, nil, done, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, ok := s.Get("key"); ok {
+		t.Errorf("Expected key to expire after the default TTL rounded up to 1s")
+	}
+}
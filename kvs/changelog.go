@@ -0,0 +1,158 @@
+package kvs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOffsetTruncated is returned when a reader asks for changes that have
+// already fallen out of the retention window. The reader has missed changes
+// and must resynchronise, e.g. with SCAN, before following the feed again.
+var ErrOffsetTruncated = errors.New("offset is older than the retained changes")
+
+// Change is one mutation of the store. Values holds the value written by a
+// set, the values pushed by a qpush and the value removed by a qpop.
+type Change struct {
+	Seq     uint64     `json:"seq"`
+	Time    time.Time  `json:"time"`
+	Op      string     `json:"op"`
+	Key     string     `json:"key"`
+	Values  []string   `json:"values,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// ChangeLog keeps the most recent changes of a store in a ring buffer. Every
+// change gets the next sequence number, starting at 1, so a reader that
+// remembers the sequence number after the last change it processed can
+// resume from there as long as it is still retained.
+type ChangeLog struct {
+	mu    sync.Mutex
+	ring  []Change
+	start int    // index of the oldest retained change
+	size  int    // number of retained changes
+	next  uint64 // sequence number of the next change
+	wake  chan struct{}
+}
+
+// NewChangeLog retains up to retention changes.
+func NewChangeLog(retention int) *ChangeLog {
+	if retention < 1 {
+		retention = 1
+	}
+	return &ChangeLog{
+		ring: make([]Change, retention),
+		next: 1,
+		wake: make(chan struct{}),
+	}
+}
+
+func (l *ChangeLog) append(c Change) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c.Seq = l.next
+	l.next++
+
+	if l.size < len(l.ring) {
+		l.ring[(l.start+l.size)%len(l.ring)] = c
+		l.size++
+	} else {
+		l.ring[l.start] = c
+		l.start = (l.start + 1) % len(l.ring)
+	}
+
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// Bounds returns the sequence number of the oldest retained change and the
+// one the next change will get. Both are equal while nothing is retained.
+func (l *ChangeLog) Bounds() (oldest, next uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.next - uint64(l.size), l.next
+}
+
+// Read returns up to max changes starting at sequence number offset, along
+// with the offset to read from next. An offset of 0 starts at the oldest
+// retained change.
+func (l *ChangeLog) Read(offset uint64, max int) ([]Change, uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	oldest := l.next - uint64(l.size)
+	if offset == 0 {
+		offset = oldest
+	}
+	if offset < oldest {
+		return nil, oldest, ErrOffsetTruncated
+	}
+	if offset >= l.next {
+		return []Change{}, offset, nil
+	}
+
+	skip := int(offset - oldest)
+	n := l.size - skip
+	if max > 0 && n > max {
+		n = max
+	}
+	changes := make([]Change, n)
+	for i := range changes {
+		changes[i] = l.ring[(l.start+skip+i)%len(l.ring)]
+	}
+	return changes, offset + uint64(n), nil
+}
+
+// Wait blocks until a change with sequence number offset or later exists, or
+// ctx is done.
+func (l *ChangeLog) Wait(ctx context.Context, offset uint64) error {
+	for {
+		l.mu.Lock()
+		if offset < l.next {
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// EnableChangeLog starts recording every mutation in a change log retaining
+// up to retention changes, and returns it.
+func (s *KeyValueStore) EnableChangeLog(retention int) *ChangeLog {
+	l := NewChangeLog(retention)
+	s.changes.Store(l)
+	return l
+}
+
+// ChangeLog returns the store's change log, or nil if it is not enabled.
+func (s *KeyValueStore) ChangeLog() *ChangeLog {
+	return s.changes.Load()
+}
+
+// record reports a mutation to the change log and to keyspace notification
+// subscribers, and counts expiries and evictions for Stats. The caller must
+// hold the write lock of the key's shard, which keeps the sequence numbers of
+// a key's changes in the order they happened.
+func (s *KeyValueStore) record(c Change) {
+	switch c.Op {
+	case EventExpired:
+		s.expiredKeys.Add(1)
+	case EventEvicted:
+		s.evictedKeys.Add(1)
+	}
+	if l := s.changes.Load(); l != nil {
+		c.Time = time.Now()
+		l.append(c)
+	}
+	s.emit(c.Op, c.Key)
+}
@@ -0,0 +1,70 @@
+package kvs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChangeLog(t *testing.T) {
+	kvs := NewKeyValueStore()
+	log := kvs.EnableChangeLog(3)
+
+	kvs.Set("a", "1", 0, "")
+	kvs.Qpush("q", []string{"x", "y"})
+	kvs.Qpop("q")
+
+	changes, next, err := log.Read(0, 10)
+	if err != nil || len(changes) != 3 || next != 4 {
+		t.Fatalf("Read() FAILED: expected 3 changes and next 4, but got %v, %v, %v", changes, next, err)
+	}
+	expected := []struct {
+		op    string
+		key   string
+		value string
+	}{
+		{EventSet, "a", "1"},
+		{EventQpush, "q", "x"},
+		{EventQpop, "q", "y"},
+	}
+	for i, e := range expected {
+		c := changes[i]
+		if c.Seq != uint64(i+1) || c.Op != e.op || c.Key != e.key || len(c.Values) == 0 || c.Values[0] != e.value {
+			t.Errorf("Read() FAILED: expected #%d %v %v %v, but got %+v", i+1, e.op, e.key, e.value, c)
+		}
+	}
+
+	// Resuming from an offset returns only what came after it.
+	changes, next, _ = log.Read(3, 10)
+	if len(changes) != 1 || changes[0].Seq != 3 || next != 4 {
+		t.Errorf("Read() FAILED: expected only #3, but got %v, next %v", changes, next)
+	}
+
+	// A fourth change pushes the first one out of the retention window.
+	kvs.Del([]string{"a"})
+	if _, oldest, err := log.Read(1, 10); err != ErrOffsetTruncated || oldest != 2 {
+		t.Errorf("Read() FAILED: expected %v with oldest 2, but got %v, %v", ErrOffsetTruncated, oldest, err)
+	}
+	if changes, _, _ := log.Read(4, 10); len(changes) != 1 || changes[0].Op != EventDel {
+		t.Errorf("Read() FAILED: expected the del change, but got %v", changes)
+	}
+}
+
+func TestChangeLogWait(t *testing.T) {
+	kvs := NewKeyValueStore()
+	log := kvs.EnableChangeLog(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := log.Wait(ctx, 1); err == nil {
+		t.Errorf("Wait() FAILED: expected a timeout with no changes")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		kvs.Set("a", "1", 0, "")
+	}()
+	if err := log.Wait(context.Background(), 1); err != nil {
+		t.Errorf("Wait() FAILED: expected to wake up on a change, but got %v", err)
+	}
+}
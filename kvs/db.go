@@ -0,0 +1,297 @@
+package kvs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Errors returned by DB.
+var (
+	ErrNotFound        = errors.New("key not found")
+	ErrConditionNotMet = errors.New("condition not met")
+	ErrWrongType       = errors.New("operation against a key holding the wrong kind of value")
+	ErrClosed          = errors.New("store is closed")
+)
+
+// DefaultCleanupInterval is how often a DB deletes expired keys in the
+// background unless WithCleanupInterval says otherwise.
+const DefaultCleanupInterval = time.Second
+
+// DB is the API for embedding the store in another program. It wraps a
+// KeyValueStore with context-aware methods, functional options and typed
+// errors, and owns the background goroutines the store needs.
+//
+//	db, err := kvs.New(kvs.WithMaxMemory(64<<20, kvs.PolicyAllKeysLRU))
+//	...
+//	defer db.Close()
+//	err = db.Set(ctx, "session:1", "alice", kvs.WithTTL(time.Hour), kvs.IfNotExists())
+type DB struct {
+	store *KeyValueStore
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+type options struct {
+	shards          int
+	maxMemory       int64
+	policy          string
+	changeRetention int
+	cleanupInterval time.Duration
+	logger          *slog.Logger
+}
+
+// Option configures a DB created by New.
+type Option func(*options)
+
+// WithShards splits the keyspace into n shards, see NewShardedStore.
+func WithShards(n int) Option {
+	return func(o *options) { o.shards = n }
+}
+
+// WithMaxMemory limits the memory used by the store, see
+// KeyValueStore.SetMaxMemory.
+func WithMaxMemory(bytes int64, policy string) Option {
+	return func(o *options) { o.maxMemory, o.policy = bytes, policy }
+}
+
+// WithChangeLog records up to retention changes, see
+// KeyValueStore.EnableChangeLog.
+func WithChangeLog(retention int) Option {
+	return func(o *options) { o.changeRetention = retention }
+}
+
+// WithCleanupInterval sets how often expired keys are deleted in the
+// background. Zero disables the cleanup, leaving expired keys to be deleted
+// when they are next looked up.
+func WithCleanupInterval(d time.Duration) Option {
+	return func(o *options) { o.cleanupInterval = d }
+}
+
+// WithLogger logs evictions, rejected writes and snapshots to l, see
+// KeyValueStore.SetLogger.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// New returns an empty store configured by opts. It must be closed with
+// Close once it is no longer needed.
+func New(opts ...Option) (*DB, error) {
+	o := options{
+		shards:          DefaultShards,
+		policy:          PolicyNoEviction,
+		cleanupInterval: DefaultCleanupInterval,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	store := NewShardedStore(o.shards)
+	store.SetLogger(o.logger)
+	if err := store.SetMaxMemory(o.maxMemory, o.policy); err != nil {
+		return nil, err
+	}
+	if o.changeRetention > 0 {
+		store.EnableChangeLog(o.changeRetention)
+	}
+
+	db := &DB{store: store, closed: make(chan struct{})}
+	if o.cleanupInterval > 0 {
+		db.wg.Add(1)
+		go db.cleanupLoop(o.cleanupInterval)
+	}
+	return db, nil
+}
+
+// Store returns the underlying store, for the parts of the API DB does not
+// cover such as Scan and the change log.
+func (db *DB) Store() *KeyValueStore {
+	return db.store
+}
+
+// Close stops the background goroutines and fails blocked and later calls
+// with ErrClosed. It is safe to call more than once.
+func (db *DB) Close() error {
+	db.closeOnce.Do(func() { close(db.closed) })
+	db.wg.Wait()
+	return nil
+}
+
+func (db *DB) cleanupLoop(interval time.Duration) {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.store.DeleteExpired()
+		case <-db.closed:
+			return
+		}
+	}
+}
+
+// check reports why a call must not go ahead, if it must not.
+func (db *DB) check(ctx context.Context) error {
+	select {
+	case <-db.closed:
+		return ErrClosed
+	default:
+		return ctx.Err()
+	}
+}
+
+type setOptions struct {
+	ttl       time.Duration
+	condition string
+	version   uint64
+	ifVersion bool
+}
+
+// SetOption modifies a DB.Set call.
+type SetOption func(*setOptions)
+
+// WithTTL makes the key expire after d. Keys never expire by default.
+func WithTTL(d time.Duration) SetOption {
+	return func(o *setOptions) { o.ttl = d }
+}
+
+// IfNotExists only sets the key if it does not exist, like SET NX.
+func IfNotExists() SetOption {
+	return func(o *setOptions) { o.condition = "NX" }
+}
+
+// IfExists only sets the key if it already exists, like SET XX.
+func IfExists() SetOption {
+	return func(o *setOptions) { o.condition = "XX" }
+}
+
+// IfVersion only sets the key if its current version is version, where 0
+// stands for a key that does not exist, like SET IFVERSION.
+func IfVersion(version uint64) SetOption {
+	return func(o *setOptions) { o.version, o.ifVersion = version, true }
+}
+
+// Set stores value at key, replacing whatever it held. It returns
+// ErrConditionNotMet if a condition option rules the write out and ErrOOM if
+// the value does not fit in the memory limit.
+func (db *DB) Set(ctx context.Context, key, value string, opts ...SetOption) error {
+	_, err := db.SetWithVersion(ctx, key, value, opts...)
+	return err
+}
+
+// SetWithVersion is Set, also returning the version the key was given.
+func (db *DB) SetWithVersion(ctx context.Context, key, value string, opts ...SetOption) (uint64, error) {
+	if err := db.check(ctx); err != nil {
+		return 0, err
+	}
+	var o setOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := db.store
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	item := s.lookup(sh, key)
+	switch {
+	case o.condition == "NX" && item != nil,
+		o.condition == "XX" && item == nil,
+		o.ifVersion && item == nil && o.version != 0,
+		o.ifVersion && item != nil && item.version != o.version:
+		return 0, ErrConditionNotMet
+	}
+
+	if err := s.reserve([]write{s.writeOf(key, value)}, sh); err != nil {
+		return 0, err
+	}
+	return s.setLocked(sh, key, value, o.ttl), nil
+}
+
+// Get returns the value at key. It returns ErrNotFound if the key does not
+// exist and ErrWrongType if it holds a queue.
+func (db *DB) Get(ctx context.Context, key string) (string, error) {
+	if err := db.check(ctx); err != nil {
+		return "", err
+	}
+	s := db.store
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item := peek(sh, key)
+	if item == nil {
+		s.misses.Add(1)
+		return "", ErrNotFound
+	}
+	if item.kind != TypeString {
+		return "", ErrWrongType
+	}
+	s.hits.Add(1)
+	s.access(item)
+	return item.queue[0].value, nil
+}
+
+// Del removes the given keys and returns how many of them existed.
+func (db *DB) Del(ctx context.Context, keys ...string) (int, error) {
+	if err := db.check(ctx); err != nil {
+		return 0, err
+	}
+	return db.store.Del(keys), nil
+}
+
+// Push appends values to the queue at key, creating it if needed. It
+// returns ErrWrongType if key holds a string.
+func (db *DB) Push(ctx context.Context, key string, values ...string) error {
+	if err := db.check(ctx); err != nil {
+		return err
+	}
+	return db.store.Qpush(key, values)
+}
+
+// Pop removes and returns the oldest value of the queue at key. It returns
+// ErrNotFound if the queue is empty or does not exist and ErrWrongType if
+// key holds a string.
+func (db *DB) Pop(ctx context.Context, key string) (string, error) {
+	if err := db.check(ctx); err != nil {
+		return "", err
+	}
+
+	s := db.store
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return s.popTyped(sh, key)
+}
+
+// BPop is Pop, but waits for a value to be pushed if the queue is empty or
+// does not exist. It gives up with ctx's error once ctx is done, and with
+// ErrClosed if the DB is closed meanwhile.
+func (db *DB) BPop(ctx context.Context, key string) (string, error) {
+	if err := db.check(ctx); err != nil {
+		return "", err
+	}
+	return db.store.waitPop(ctx, key, db.closed)
+}
+
+// popTyped is popFront with DB's errors. The caller must hold sh.mu for
+// writing.
+func (s *KeyValueStore) popTyped(sh *shard, key string) (string, error) {
+	item := s.lookup(sh, key)
+	if item != nil && item.kind == TypeString {
+		return "", ErrWrongType
+	}
+	val, ok := s.popFront(sh, key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return val, nil
+}
@@ -0,0 +1,131 @@
+package kvs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDBSetAndGet(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	if _, err := db.Get(ctx, "key"); err != ErrNotFound {
+		t.Errorf("Get() FAILED: expected %v, but got %v", ErrNotFound, err)
+	}
+	if err := db.Set(ctx, "key", "value", IfExists()); err != ErrConditionNotMet {
+		t.Errorf("Set(IfExists) FAILED: expected %v, but got %v", ErrConditionNotMet, err)
+	}
+	if err := db.Set(ctx, "key", "value", IfNotExists()); err != nil {
+		t.Errorf("Set(IfNotExists) FAILED: %v", err)
+	}
+	if err := db.Set(ctx, "key", "other", IfNotExists()); err != ErrConditionNotMet {
+		t.Errorf("Set(IfNotExists) FAILED: expected %v, but got %v", ErrConditionNotMet, err)
+	}
+	if val, err := db.Get(ctx, "key"); val != "value" || err != nil {
+		t.Errorf("Get() FAILED: expected value, but got %q, %v", val, err)
+	}
+
+	version := db.Store().Version("key")
+	if err := db.Set(ctx, "key", "new", IfVersion(version+1)); err != ErrConditionNotMet {
+		t.Errorf("Set(IfVersion) FAILED: expected %v, but got %v", ErrConditionNotMet, err)
+	}
+	if err := db.Set(ctx, "key", "new", IfVersion(version)); err != nil {
+		t.Errorf("Set(IfVersion) FAILED: %v", err)
+	}
+
+	if err := db.Set(ctx, "short", "lived", WithTTL(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := db.Get(ctx, "short"); err != ErrNotFound {
+		t.Errorf("Get() FAILED: expected an expired key to be %v, but got %v", ErrNotFound, err)
+	}
+}
+
+func TestDBWrongType(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	db.Set(ctx, "string", "value")
+	db.Push(ctx, "queue", "a")
+
+	if _, err := db.Get(ctx, "queue"); err != ErrWrongType {
+		t.Errorf("Get() FAILED: expected %v, but got %v", ErrWrongType, err)
+	}
+	if err := db.Push(ctx, "string", "a"); err != ErrWrongType {
+		t.Errorf("Push() FAILED: expected %v, but got %v", ErrWrongType, err)
+	}
+	if _, err := db.Pop(ctx, "string"); err != ErrWrongType {
+		t.Errorf("Pop() FAILED: expected %v, but got %v", ErrWrongType, err)
+	}
+}
+
+func TestDBBPop(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		db.Push(ctx, "queue", "a", "b")
+	}()
+	if val, err := db.BPop(ctx, "queue"); val != "a" || err != nil {
+		t.Errorf("BPop() FAILED: expected a, but got %q, %v", val, err)
+	}
+	if val, err := db.Pop(ctx, "queue"); val != "b" || err != nil {
+		t.Errorf("Pop() FAILED: expected b, but got %q, %v", val, err)
+	}
+	if _, err := db.Pop(ctx, "queue"); err != ErrNotFound {
+		t.Errorf("Pop() FAILED: expected %v, but got %v", ErrNotFound, err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.BPop(timeout, "queue"); err != context.DeadlineExceeded {
+		t.Errorf("BPop() FAILED: expected %v, but got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestDBClose(t *testing.T) {
+	db, err := New(WithCleanupInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	db.Set(ctx, "key", "value", WithTTL(time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	if keys := db.Store().Keys("*"); len(keys) != 0 {
+		t.Errorf("cleanup FAILED: expected expired keys to be deleted, but got %v", keys)
+	}
+	// Expired keys are filtered by Keys anyway, so look at the accounting.
+	if used := db.Store().UsedMemory(); used != 0 {
+		t.Errorf("cleanup FAILED: expected 0 bytes used, but got %v", used)
+	}
+
+	result := make(chan error)
+	go func() {
+		_, err := db.BPop(ctx, "queue")
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	db.Close()
+	if err := <-result; err != ErrClosed {
+		t.Errorf("BPop() FAILED: expected %v, but got %v", ErrClosed, err)
+	}
+	if err := db.Set(ctx, "key", "value"); err != ErrClosed {
+		t.Errorf("Set() FAILED: expected %v, but got %v", ErrClosed, err)
+	}
+}
@@ -0,0 +1,105 @@
+package kvs
+
+// MatchPattern reports whether str matches the Redis-style glob pattern.
+//
+// '*' matches any sequence of characters, '?' matches exactly one, and
+// '[...]' matches one character from a class such as [abc], [a-z] or, negated,
+// [^abc]. A backslash matches the following character literally.
+func MatchPattern(pattern, str string) bool {
+	p, s := []rune(pattern), []rune(str)
+	return matchRunes(p, s)
+}
+
+// matchRunes matches s against p from left to right. On a mismatch it
+// resumes after the last '*' seen, letting that '*' take one more character;
+// an earlier '*' never needs to be retried, so the match takes O(len(p) *
+// len(s)) steps rather than backtracking exponentially.
+func matchRunes(p, s []rune) bool {
+	pi, si := 0, 0
+	star, starS := -1, 0 // index in p after the last '*', and where it started in s
+	for si < len(s) {
+		if pi < len(p) && p[pi] == '*' {
+			for pi < len(p) && p[pi] == '*' {
+				pi++
+			}
+			star, starS = pi, si
+			continue
+		}
+		if pi < len(p) {
+			if next, ok := matchOne(p, pi, s[si]); ok {
+				pi, si = next, si+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starS++
+		pi, si = star, starS
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// matchOne matches c against the single-character element of p at pi, any
+// but '*'. It returns whether c matched and the index of the next element.
+func matchOne(p []rune, pi int, c rune) (int, bool) {
+	switch p[pi] {
+	case '?':
+		return pi + 1, true
+	case '[':
+		matched, rest := matchClass(p[pi+1:], c)
+		return len(p) - len(rest), matched
+	case '\\':
+		if pi+1 < len(p) {
+			pi++
+		}
+	}
+	return pi + 1, p[pi] == c
+}
+
+// matchClass matches c against the character class that starts right after
+// the opening '['. It returns whether c matched and the pattern remaining
+// after the closing ']'. An unterminated class runs to the end of the pattern.
+func matchClass(p []rune, c rune) (bool, []rune) {
+	negate := false
+	if len(p) > 0 && p[0] == '^' {
+		negate = true
+		p = p[1:]
+	}
+
+	matched := false
+	for len(p) > 0 && p[0] != ']' {
+		switch {
+		case p[0] == '\\' && len(p) > 1:
+			if p[1] == c {
+				matched = true
+			}
+			p = p[2:]
+		case len(p) > 2 && p[1] == '-' && p[2] != ']':
+			lo, hi := p[0], p[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			p = p[3:]
+		default:
+			if p[0] == c {
+				matched = true
+			}
+			p = p[1:]
+		}
+	}
+	if len(p) > 0 {
+		p = p[1:] // skip the closing ']'
+	}
+
+	if negate {
+		matched = !matched
+	}
+	return matched, p
+}
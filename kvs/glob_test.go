@@ -0,0 +1,62 @@
+package kvs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"*", "", true},
+		{"user:*", "user:42", true},
+		{"user:*", "order:42", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"ключ:*", "ключ:1", true},
+		{"*a", "aaa", true},
+		{"a*", "", false},
+		{"**", "", true},
+		{"[", "x", false},
+		{"h\\", "h\\", true},
+	}
+
+	for _, c := range cases {
+		if got := MatchPattern(c.pattern, c.str); got != c.match {
+			t.Errorf("MatchPattern(%q, %q) FAILED: expected %v, but got %v", c.pattern, c.str, c.match, got)
+		}
+	}
+}
+
+func TestMatchPatternPathological(t *testing.T) {
+	// Backtracking over each '*' would take exponential time here.
+	pattern := strings.Repeat("*a", 30) + "*b"
+	str := strings.Repeat("a", 10000)
+
+	done := make(chan bool, 1)
+	go func() { done <- MatchPattern(pattern, str) }()
+	select {
+	case match := <-done:
+		if match {
+			t.Errorf("MatchPattern() FAILED: expected no match, but got one")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("MatchPattern() FAILED: still matching after 5s")
+	}
+}
@@ -0,0 +1,29 @@
+package kvs
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SetLogger sets where the store logs evictions, rejected writes and
+// snapshots. A nil logger, the default, discards everything.
+func (s *KeyValueStore) SetLogger(l *slog.Logger) {
+	s.logger.Store(l)
+}
+
+// log returns the logger set with SetLogger, or one that discards.
+func (s *KeyValueStore) log() *slog.Logger {
+	if l := s.logger.Load(); l != nil {
+		return l
+	}
+	return discard
+}
+
+var discard = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
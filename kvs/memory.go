@@ -0,0 +1,340 @@
+package kvs
+
+import (
+	"errors"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrOOM is returned for writes that would take the store over its memory
+// limit when nothing can be evicted to make room.
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+
+// Eviction policies, as in Redis' maxmemory-policy.
+const (
+	PolicyNoEviction    = "noeviction"
+	PolicyAllKeysLRU    = "allkeys-lru"
+	PolicyAllKeysLFU    = "allkeys-lfu"
+	PolicyAllKeysRandom = "allkeys-random"
+	PolicyVolatileLRU   = "volatile-lru"
+	PolicyVolatileTTL   = "volatile-ttl"
+)
+
+var policies = map[string]bool{
+	PolicyNoEviction:    true,
+	PolicyAllKeysLRU:    true,
+	PolicyAllKeysLFU:    true,
+	PolicyAllKeysRandom: true,
+	PolicyVolatileLRU:   true,
+	PolicyVolatileTTL:   true,
+}
+
+// Approximate per-entry costs on top of the key and value bytes, used for
+// memory accounting. They only need to be in the right ballpark.
+const (
+	entryOverhead = 128 // map slot, QueueChannel and its string header
+	slotOverhead  = 16  // one buffered string in the entry's channel
+	itemOverhead  = 56  // KeyValueItem, its pointer and expiration time
+)
+
+// evictionSamples is how many keys are looked at to pick each victim. Like
+// Redis, eviction is approximate: the best of a small random sample is
+// evicted rather than the best key overall.
+const evictionSamples = 5
+
+// LFU counter parameters, following Redis: the counter grows
+// logarithmically with accesses and decays by one per minute of inactivity.
+const (
+	lfuInitValue = 5
+	lfuLogFactor = 10
+)
+
+// entrySize estimates the memory a new entry for key holding values needs.
+func (s *KeyValueStore) entrySize(key string, values ...string) int64 {
+	size := int64(len(key)) + entryOverhead + int64(s.QueueBuffer())*slotOverhead
+	for _, value := range values {
+		size += itemSize(value)
+	}
+	return size
+}
+
+// write is a key a command is about to write and the memory it may take.
+type write struct {
+	key  string
+	size int64
+}
+
+// writeOf estimates a write of values to key as entrySize does, erring on
+// the side of refusing when key already exists.
+func (s *KeyValueStore) writeOf(key string, values ...string) write {
+	return write{key, s.entrySize(key, values...)}
+}
+
+func baseSize(key string, item *QueueChannel) int64 {
+	return int64(len(key)) + entryOverhead + int64(cap(item.channel))*slotOverhead
+}
+
+func itemSize(value string) int64 {
+	return int64(len(value)) + itemOverhead
+}
+
+// resize adjusts the accounted size of item by delta. The caller must hold
+// the item's shard lock for writing.
+func (s *KeyValueStore) resize(item *QueueChannel, delta int64) {
+	item.size += delta
+	s.used.Add(delta)
+	if item.tenant != nil {
+		item.tenant.memory.Add(delta)
+	}
+}
+
+// remove deletes key and its accounted memory. The caller must hold sh.mu
+// for writing.
+func (s *KeyValueStore) remove(sh *shard, key string) {
+	if item, exists := sh.store[key]; exists {
+		s.used.Add(-item.size)
+		if item.tenant != nil {
+			item.tenant.keys.Add(-1)
+			item.tenant.memory.Add(-item.size)
+		}
+		delete(sh.store, key)
+	}
+}
+
+// access records a read or write of item for the LRU and LFU policies. The
+// caller must hold the item's shard lock, for reading at least; concurrent
+// readers may race on the counter, which only makes it approximate.
+func (s *KeyValueStore) access(item *QueueChannel) {
+	now := time.Now()
+	freq := lfuDecay(item, now)
+	if freq < 255 {
+		base := float64(0)
+		if freq > lfuInitValue {
+			base = float64(freq - lfuInitValue)
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			freq++
+		}
+	}
+	item.freq.Store(uint32(freq))
+	item.lastAccess.Store(now.UnixNano())
+}
+
+// lfuDecay returns item's LFU counter after aging it by the time since its
+// last access.
+func lfuDecay(item *QueueChannel, now time.Time) uint8 {
+	lastAccess := item.lastAccess.Load()
+	if lastAccess == 0 {
+		return lfuInitValue
+	}
+	freq := uint8(item.freq.Load())
+	minutes := now.Sub(time.Unix(0, lastAccess)) / time.Minute
+	if int64(minutes) >= int64(freq) {
+		return 0
+	}
+	return freq - uint8(minutes)
+}
+
+// SetMaxMemory limits the memory used by the store to maxMemory bytes, or
+// lifts the limit if it is 0, and selects how room is made once it is
+// reached.
+func (s *KeyValueStore) SetMaxMemory(maxMemory int64, policy string) error {
+	if !policies[policy] {
+		return errors.New("invalid maxmemory-policy: " + policy)
+	}
+	if maxMemory < 0 {
+		return errors.New("invalid maxmemory")
+	}
+
+	s.policy.Store(policy)
+	s.maxMemory.Store(maxMemory)
+	return nil
+}
+
+// MaxMemory returns the memory limit and eviction policy.
+func (s *KeyValueStore) MaxMemory() (int64, string) {
+	return s.maxMemory.Load(), s.evictionPolicy()
+}
+
+func (s *KeyValueStore) evictionPolicy() string {
+	if policy, ok := s.policy.Load().(string); ok {
+		return policy
+	}
+	return PolicyNoEviction
+}
+
+// UsedMemory returns the approximate number of bytes held by the store.
+func (s *KeyValueStore) UsedMemory() int64 {
+	return s.used.Load()
+}
+
+// reserve checks writes against the tenant quotas, see SetTenantQuotas, and
+// makes room for them, evicting keys as the policy allows. The caller must
+// hold the write locks of held, the shards it is about to write to. Victims
+// in other shards are only taken from shards whose lock is free, so reserve
+// never waits on another writer.
+func (s *KeyValueStore) reserve(writes []write, held ...*shard) error {
+	if err := s.checkQuotas(writes); err != nil {
+		return err
+	}
+	need := int64(0)
+	for _, w := range writes {
+		need += w.size
+	}
+	maxMemory := s.maxMemory.Load()
+	if maxMemory <= 0 {
+		return nil
+	}
+	policy := s.evictionPolicy()
+	for s.used.Load()+need > maxMemory {
+		if policy == PolicyNoEviction || !s.evictOne(policy, held) {
+			s.log().Debug("write rejected, out of memory", "need", need, "used", s.used.Load(), "maxmemory", maxMemory, "policy", policy)
+			return ErrOOM
+		}
+	}
+	return nil
+}
+
+// evictionAttempts bounds how often evictOne samples again after finding
+// the shards it wanted locked by other writers.
+const evictionAttempts = 64
+
+// evictOne evicts a key as the policy says, see tryEvict. Shards held by
+// other writers only make it try again, so that concurrent writes do not
+// fail while there are keys to evict. It reports false if nothing could be
+// evicted.
+func (s *KeyValueStore) evictOne(policy string, held []*shard) bool {
+	for attempt := 0; attempt < evictionAttempts; attempt++ {
+		evicted, busy := s.tryEvict(policy, held)
+		if evicted {
+			return true
+		}
+		if !busy {
+			return false // nothing the policy may evict
+		}
+		runtime.Gosched()
+	}
+	return false
+}
+
+// tryEvict evicts the best of evictionSamples keys, sampled from the shards
+// in turn starting at a random one. It reports whether a key was evicted,
+// and whether a shard was skipped because another writer held it.
+func (s *KeyValueStore) tryEvict(policy string, held []*shard) (evicted, busy bool) {
+	var (
+		victim  string
+		owner   *shard
+		best    uint64
+		sampled int
+	)
+	shards := s.shardList()
+	offset := rand.Intn(len(shards))
+	for i := 0; i < len(shards) && sampled < evictionSamples; i++ {
+		sh := shards[(offset+i)%len(shards)]
+		if !s.lockForEviction(sh, held) {
+			busy = true
+			continue
+		}
+		key, score, n := pickVictim(sh, policy, evictionSamples-sampled)
+		if n > 0 && (owner == nil || score < best) {
+			victim, owner, best = key, sh, score
+		}
+		sampled += n
+		s.unlockForEviction(sh, held)
+
+		if policy == PolicyAllKeysRandom && owner != nil {
+			break
+		}
+	}
+	if owner == nil {
+		return false, busy
+	}
+	if !s.lockForEviction(owner, held) {
+		return false, true
+	}
+	defer s.unlockForEviction(owner, held)
+
+	// The victim may have gone while its shard was unlocked.
+	if _, exists := owner.store[victim]; !exists {
+		return true, false
+	}
+	s.remove(owner, victim)
+	s.record(Change{Op: EventEvicted, Key: victim})
+	s.log().Debug("key evicted", "key", victim, "policy", policy)
+	return true, false
+}
+
+// lockForEviction locks sh unless the caller already holds it. Shards held
+// by other writers are skipped rather than waited for, which could deadlock.
+func (s *KeyValueStore) lockForEviction(sh *shard, held []*shard) bool {
+	return holds(held, sh) || sh.mu.TryLock()
+}
+
+func (s *KeyValueStore) unlockForEviction(sh *shard, held []*shard) {
+	if !holds(held, sh) {
+		sh.mu.Unlock()
+	}
+}
+
+// pickVictim samples up to limit keys of sh that the policy may evict and
+// returns the best candidate among them with its score, lowest first, and
+// the number of keys sampled. The caller must hold sh.mu.
+func pickVictim(sh *shard, policy string, limit int) (string, uint64, int) {
+	volatile := policy == PolicyVolatileLRU || policy == PolicyVolatileTTL
+	now := time.Now()
+
+	victim := ""
+	var best uint64
+	sampled := 0
+	for key, item := range sh.store {
+		if volatile && (len(item.queue) == 0 || item.queue[0].expiration == nil) {
+			continue
+		}
+
+		var score uint64
+		switch policy {
+		case PolicyAllKeysLRU, PolicyVolatileLRU:
+			score = uint64(item.lastAccess.Load())
+		case PolicyAllKeysLFU:
+			// Least frequently used first, least recently used among equals.
+			score = uint64(lfuDecay(item, now))<<56 | uint64(item.lastAccess.Load())>>8
+		case PolicyVolatileTTL:
+			score = uint64(item.queue[0].expiration.UnixNano())
+		}
+		if sampled == 0 || score < best {
+			victim, best = key, score
+		}
+
+		sampled++
+		if policy == PolicyAllKeysRandom || sampled == limit {
+			break
+		}
+	}
+	return victim, best, sampled
+}
+
+// ParseMemory parses a byte count with an optional kb, mb or gb suffix, as
+// in "100mb". Suffixes are powers of 1024.
+func ParseMemory(spec string) (int64, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"b", 1}} {
+		if strings.HasSuffix(spec, unit.suffix) {
+			spec = strings.TrimSuffix(spec, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(spec, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid memory size")
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,132 @@
+package kvs
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryAccounting(t *testing.T) {
+	kvs := NewKeyValueStore()
+
+	kvs.Set("key", "value", 0, "")
+	kvs.Qpush("queue", []string{"a", "b", "c"})
+	if kvs.UsedMemory() <= 0 {
+		t.Errorf("UsedMemory() FAILED: expected a positive size, but got %v", kvs.UsedMemory())
+	}
+
+	kvs.Set("key", "a much longer value than before", 0, "")
+	kvs.Qpop("queue")
+	kvs.Bqpop("queue", 0)
+	kvs.Qpop("queue")
+	kvs.Del([]string{"key", "queue"})
+	if used := kvs.UsedMemory(); used != 0 {
+		t.Errorf("UsedMemory() FAILED: expected 0 once everything is removed, but got %v", used)
+	}
+}
+
+func TestNoEviction(t *testing.T) {
+	kvs := NewKeyValueStore()
+	if err := kvs.SetMaxMemory(2*kvs.entrySize("k1", "v1"), PolicyNoEviction); err != nil {
+		t.Fatal(err)
+	}
+
+	kvs.Set("k1", "v1", 0, "")
+	kvs.Set("k2", "v2", 0, "")
+	if _, ok, err := kvs.SetWithVersion("k3", "v3", 0, ""); ok || err != ErrOOM {
+		t.Errorf("SetWithVersion() FAILED: expected %v, but got %v, %v", ErrOOM, ok, err)
+	}
+	if err := kvs.Qpush("q", []string{"x"}); err != ErrOOM {
+		t.Errorf("Qpush() FAILED: expected %v, but got %v", ErrOOM, err)
+	}
+	if _, ok := kvs.Get("k1"); !ok {
+		t.Errorf("noeviction FAILED: must never remove keys")
+	}
+
+	if err := kvs.SetMaxMemory(0, "bogus"); err == nil {
+		t.Errorf("SetMaxMemory() FAILED: expected an error for an unknown policy")
+	}
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	cases := []struct {
+		policy  string
+		evicted string
+	}{
+		{PolicyAllKeysLRU, "old"},
+		{PolicyAllKeysLFU, "old"},
+		{PolicyVolatileLRU, "volatile"},
+		{PolicyVolatileTTL, "soon"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.policy, func(t *testing.T) {
+			kvs := NewKeyValueStore()
+			kvs.Set("old", "1", 0, "")
+			time.Sleep(time.Millisecond)
+			kvs.Set("volatile", "2", 1000, "")
+			kvs.Set("soon", "3", 100, "")
+			for i := 0; i < 50; i++ {
+				kvs.Get("volatile")
+				kvs.Get("soon")
+			}
+
+			if err := kvs.SetMaxMemory(kvs.UsedMemory(), c.policy); err != nil {
+				t.Fatal(err)
+			}
+			if !kvs.Set("new", "4", 0, "") {
+				t.Fatalf("Set() FAILED: expected room to be made")
+			}
+			if _, ok := kvs.Get(c.evicted); ok {
+				t.Errorf("%v FAILED: expected %v to be evicted", c.policy, c.evicted)
+			}
+			if kvs.UsedMemory() > kvs.maxMemory.Load() {
+				t.Errorf("%v FAILED: used %v is over the limit %v", c.policy, kvs.UsedMemory(), kvs.maxMemory.Load())
+			}
+		})
+	}
+}
+
+func TestConcurrentEviction(t *testing.T) {
+	// Few shards and parallel writers, so that writers often hold the shards
+	// eviction wants.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	kvs := NewShardedStore(4)
+	if err := kvs.SetMaxMemory(20*kvs.entrySize("key-0-0", "value"), PolicyAllKeysLRU); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				if _, _, err := kvs.SetWithVersion("key-"+strconv.Itoa(w)+"-"+strconv.Itoa(i), "value", 0, ""); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("SetWithVersion() FAILED: expected room to be made at the limit, but got %v", err)
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	cases := map[string]int64{"100": 100, "1kb": 1024, "2MB": 2 << 20, "1gb": 1 << 30, "10b": 10}
+	for spec, expected := range cases {
+		if n, err := ParseMemory(spec); err != nil || n != expected {
+			t.Errorf("ParseMemory(%q) FAILED: expected %v, but got %v, %v", spec, expected, n, err)
+		}
+	}
+	if _, err := ParseMemory("lots"); err == nil {
+		t.Errorf("ParseMemory() FAILED: expected an error")
+	}
+}
@@ -0,0 +1,142 @@
+package kvs
+
+import (
+	"errors"
+	"strings"
+)
+
+// Keyspace notifications publish every write to the store on two families of
+// channels, in the same format as Redis:
+//
+//	__keyspace@0__:<key>    with the event name as the message
+//	__keyevent@0__:<event>  with the key as the message
+//
+// so subscribers can pick keys with a pattern on the first and event types on
+// the second.
+const (
+	KeyspaceChannelPrefix = "__keyspace@0__:"
+	KeyeventChannelPrefix = "__keyevent@0__:"
+)
+
+// Events emitted by the store.
+const (
+	EventSet     = "set"
+	EventDel     = "del"
+	EventExpired = "expired"
+	EventEvicted = "evicted"
+	EventQpush   = "qpush"
+	EventQpop    = "qpop"
+)
+
+// NotifyFlags selects which notifications are published. It is configured
+// with the Redis notify-keyspace-events letters:
+//
+//	K  keyspace channels       E  keyevent channels
+//	g  generic events (del)    $  string events (set)
+//	l  queue events (qpush, qpop)
+//	x  expired events          e  evicted events
+//	A  alias for "g$lxe"
+//
+// At least one of K or E and one event class must be given for anything to
+// be published.
+type NotifyFlags uint
+
+const (
+	NotifyKeyspace NotifyFlags = 1 << iota
+	NotifyKeyevent
+	NotifyGeneric
+	NotifyString
+	NotifyQueue
+	NotifyExpired
+	NotifyEvicted
+
+	NotifyAll = NotifyGeneric | NotifyString | NotifyQueue | NotifyExpired | NotifyEvicted
+)
+
+var notifyLetters = []struct {
+	letter byte
+	flag   NotifyFlags
+}{
+	{'K', NotifyKeyspace},
+	{'E', NotifyKeyevent},
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'l', NotifyQueue},
+	{'x', NotifyExpired},
+	{'e', NotifyEvicted},
+}
+
+// eventClass maps each event to the flag that enables it.
+var eventClass = map[string]NotifyFlags{
+	EventSet:     NotifyString,
+	EventDel:     NotifyGeneric,
+	EventExpired: NotifyExpired,
+	EventEvicted: NotifyEvicted,
+	EventQpush:   NotifyQueue,
+	EventQpop:    NotifyQueue,
+}
+
+func ParseNotifyFlags(spec string) (NotifyFlags, error) {
+	var flags NotifyFlags
+	for i := 0; i < len(spec); i++ {
+		if spec[i] == 'A' {
+			flags |= NotifyAll
+			continue
+		}
+		found := false
+		for _, l := range notifyLetters {
+			if l.letter == spec[i] {
+				flags |= l.flag
+				found = true
+			}
+		}
+		if !found {
+			return 0, errors.New("invalid notify-keyspace-events flag: " + string(spec[i]))
+		}
+	}
+	return flags, nil
+}
+
+func (f NotifyFlags) String() string {
+	var b strings.Builder
+	for _, l := range notifyLetters {
+		if f&l.flag != 0 {
+			b.WriteByte(l.letter)
+		}
+	}
+	return b.String()
+}
+
+// SetNotifications publishes keyspace notifications selected by spec on ps.
+// An empty spec turns them off.
+func (s *KeyValueStore) SetNotifications(ps *PubSub, spec string) error {
+	flags, err := ParseNotifyFlags(spec)
+	if err != nil {
+		return err
+	}
+
+	s.events.Store(ps)
+	s.notify.Store(uint32(flags))
+	return nil
+}
+
+// Notifications returns the notify-keyspace-events letters in effect.
+func (s *KeyValueStore) Notifications() string {
+	return NotifyFlags(s.notify.Load()).String()
+}
+
+// emit publishes event for key if it is enabled. Since it runs under the
+// key's shard lock, notifications for a key are published in the order the
+// writes happened. The caller must hold that lock for writing.
+func (s *KeyValueStore) emit(event, key string) {
+	events, notify := s.events.Load(), NotifyFlags(s.notify.Load())
+	if events == nil || notify&eventClass[event] == 0 {
+		return
+	}
+	if notify&NotifyKeyspace != 0 {
+		events.Publish(KeyspaceChannelPrefix+key, event)
+	}
+	if notify&NotifyKeyevent != 0 {
+		events.Publish(KeyeventChannelPrefix+event, key)
+	}
+}
@@ -0,0 +1,60 @@
+package kvs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNotifyFlags(t *testing.T) {
+	flags, err := ParseNotifyFlags("KEA")
+	if err != nil || flags != NotifyKeyspace|NotifyKeyevent|NotifyAll {
+		t.Errorf("ParseNotifyFlags() FAILED: expected all flags, but got %v, %v", flags, err)
+	}
+	if flags.String() != "KEg$lxe" {
+		t.Errorf("String() FAILED: expected KEg$lxe, but got %v", flags.String())
+	}
+	if _, err := ParseNotifyFlags("Kz"); err == nil {
+		t.Errorf("ParseNotifyFlags() FAILED: expected an error for an unknown flag")
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	kvs := NewKeyValueStore()
+	ps := NewPubSub()
+	if err := kvs.SetNotifications(ps, "Kg$x"); err != nil {
+		t.Fatal(err)
+	}
+
+	keyspace := ps.Subscribe(nil, []string{KeyspaceChannelPrefix + "user:*"}, 10)
+	keyevent := ps.Subscribe([]string{KeyeventChannelPrefix + EventSet}, nil, 10)
+
+	kvs.Set("user:1", "a", 1, "")
+	kvs.Set("other", "b", 0, "")
+	kvs.Qpush("user:2", []string{"x"}) // queue events are not enabled
+	kvs.Del([]string{"user:1", "missing"})
+
+	kvs.Set("user:3", "c", 1, "")
+	time.Sleep(1100 * time.Millisecond)
+	kvs.Get("user:3")
+
+	expected := []Message{
+		{Channel: KeyspaceChannelPrefix + "user:1", Payload: EventSet},
+		{Channel: KeyspaceChannelPrefix + "user:1", Payload: EventDel},
+		{Channel: KeyspaceChannelPrefix + "user:3", Payload: EventSet},
+		{Channel: KeyspaceChannelPrefix + "user:3", Payload: EventExpired},
+	}
+	if len(keyspace.C) != len(expected) {
+		t.Fatalf("Keyspace FAILED: expected %v messages, but got %v", len(expected), len(keyspace.C))
+	}
+	for _, e := range expected {
+		msg := <-keyspace.C
+		if msg.Channel != e.Channel || msg.Payload != e.Payload {
+			t.Errorf("Keyspace FAILED: expected %v %v, but got %v %v", e.Channel, e.Payload, msg.Channel, msg.Payload)
+		}
+	}
+
+	// Only K was enabled, so nothing goes to keyevent channels.
+	if len(keyevent.C) != 0 {
+		t.Errorf("Keyevent FAILED: expected no messages, but got %v", len(keyevent.C))
+	}
+}
@@ -1,14 +1,53 @@
 package kvs
 
 import (
+	"context"
+	"log/slog"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// KeyValueStore is an in-memory store of string values and queues. The
+// keyspace is split into shards with a lock each, see NewShardedStore. The
+// zero value is an empty store with the defaults of NewKeyValueStore.
 type KeyValueStore struct {
-	mu    sync.Mutex
-	Store map[string]*QueueChannel
+	shards   []*shard
+	initOnce sync.Once     // sets up a zero value, see lazyInit
+	version  atomic.Uint64 // last version handed out by touch
+
+	// Keyspace notifications, see SetNotifications.
+	events atomic.Pointer[PubSub]
+	notify atomic.Uint32 // NotifyFlags
+
+	changes atomic.Pointer[ChangeLog] // nil unless EnableChangeLog was called
+
+	// Memory accounting and eviction, see SetMaxMemory.
+	used      atomic.Int64
+	maxMemory atomic.Int64
+	policy    atomic.Value              // string
+	tenants   atomic.Pointer[[]*tenant] // see SetTenantQuotas
+
+	quotaRejected sync.Map // *atomic.Uint64 by QuotaError
+
+	// Tunable defaults, see settings.go.
+	defaultTTL  atomic.Int64 // time.Duration
+	queueTTL    atomic.Int64 // time.Duration
+	queueBuffer atomic.Int64
+
+	// Counters for Stats.
+	expiredKeys atomic.Uint64
+	evictedKeys atomic.Uint64
+	blocked     atomic.Int64  // callers waiting in waitPop
+	hits        atomic.Uint64 // reads of a key that existed
+	misses      atomic.Uint64 // reads of a key that did not
+
+	// Persistence, see SaveFile.
+	lastSave    atomic.Int64  // unix seconds, 0 if never saved or loaded
+	saveVersion atomic.Uint64 // version at the last save or load
+
+	logger atomic.Pointer[slog.Logger] // see SetLogger
 }
 
 type KeyValueItem struct {
@@ -17,90 +56,344 @@ type KeyValueItem struct {
 }
 
 type QueueChannel struct {
-	queue []*KeyValueItem
+	kind    string
+	queue   []*KeyValueItem
 	channel chan string
+	version uint64 // bumped on every write, see touch
+
+	size   int64   // approximate bytes held, see resize
+	tenant *tenant // the keys this one counts towards, if any
+
+	// Access metadata for eviction. These are updated by readers holding
+	// only a read lock, hence atomic.
+	lastAccess atomic.Int64  // unix nanoseconds, for LRU eviction
+	freq       atomic.Uint32 // logarithmic access counter, for LFU eviction
+}
+
+// Types reported for a key, used by SCAN's TYPE filter.
+const (
+	TypeString = "string"
+	TypeQueue  = "queue"
+	TypeNone   = "none"
+)
+
+// expired reports whether the entry's head item has passed its expiration.
+func (q *QueueChannel) expired(now time.Time) bool {
+	if len(q.queue) == 0 || q.queue[0].expiration == nil {
+		return false
+	}
+	return now.After(*q.queue[0].expiration)
+}
+
+// touch records a write to item by giving it the next version. Versions come
+// from a single store-wide counter, so a key that is deleted and created
+// again never returns to a version a client may have seen before. The caller
+// must hold the item's shard lock for writing.
+func (s *KeyValueStore) touch(item *QueueChannel) {
+	item.version = s.version.Add(1)
 }
 
+// Version returns the current version of key, or 0 if it does not exist.
+func (s *KeyValueStore) Version(key string) uint64 {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item := peek(sh, key)
+	if item == nil {
+		return 0
+	}
+	return item.version
+}
+
+// Set stores value at key, expiring it after expiration seconds. An
+// expiration of 0 or less means the key never expires, as TestSetAndGet has
+// always expected. condition is "NX" or "XX", or "" to always set.
 func (s *KeyValueStore) Set(key, value string, expiration int, condition string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	_, ok, _ := s.SetWithVersion(key, value, expiration, condition)
+	return ok
+}
+
+// SetWithVersion is Set, also returning the version the key was given. It
+// fails with ErrOOM when the value does not fit in the memory limit.
+func (s *KeyValueStore) SetWithVersion(key, value string, expiration int, condition string) (uint64, bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	_, exists := s.Store[key]
+	exists := s.lookup(sh, key) != nil
 
 	if strings.EqualFold(condition, "NX") {
 		if exists {
-			return false
+			return 0, false, nil
 		}
 	} else if strings.EqualFold(condition, "XX") {
 		if !exists {
-			return false
+			return 0, false, nil
 		}
 	}
 
-	exp := time.Now().Add(time.Duration(expiration) * time.Second)
-	s.Store[key] = &QueueChannel{
-		queue:   []*KeyValueItem{{value: value, expiration: &exp}},
-		channel: make(chan string, 25),
+	if err := s.reserve([]write{s.writeOf(key, value)}, sh); err != nil {
+		return 0, false, err
 	}
+	return s.setLocked(sh, key, value, seconds(expiration)), true, nil
+}
+
+// CompareAndSet sets key only if its current version is version, where 0
+// stands for a key that does not exist. It returns the new version on
+// success and the current one otherwise.
+func (s *KeyValueStore) CompareAndSet(key, value string, expiration int, version uint64) (uint64, bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	return true
+	current := uint64(0)
+	if item := s.lookup(sh, key); item != nil {
+		current = item.version
+	}
+	if current != version {
+		return current, false, nil
+	}
+
+	if err := s.reserve([]write{s.writeOf(key, value)}, sh); err != nil {
+		return current, false, err
+	}
+	return s.setLocked(sh, key, value, seconds(expiration)), true, nil
 }
 
-func (s *KeyValueStore) Get(key string) (string, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// seconds converts an expiration in seconds, as taken by the commands, to a
+// time-to-live.
+func seconds(expiration int) time.Duration {
+	return time.Duration(expiration) * time.Second
+}
 
-	item, exists := s.Store[key]
+// setLocked stores a string value and returns its version. A non-positive
+// ttl means the key never expires. The caller must hold sh.mu for writing.
+func (s *KeyValueStore) setLocked(sh *shard, key, value string, ttl time.Duration) uint64 {
+	var exp *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		exp = &t
+	}
+	item := &QueueChannel{
+		kind:    TypeString,
+		queue:   []*KeyValueItem{{value: value, expiration: exp}},
+		channel: make(chan string, s.QueueBuffer()),
+	}
+	s.touch(item)
+	s.access(item)
+	s.remove(sh, key)
+	s.insert(sh, key, item)
+	s.resize(item, baseSize(key, item)+itemSize(value))
+	s.record(Change{Op: EventSet, Key: key, Values: []string{value}, Expires: exp})
+	return item.version
+}
+
+// peek returns the live entry for key without modifying the shard, so it
+// only needs sh.mu held for reading. Expired entries are reported missing
+// and left for lookup to delete.
+func peek(sh *shard, key string) *QueueChannel {
+	item, exists := sh.store[key]
+	if !exists || item.expired(time.Now()) {
+		return nil
+	}
+	return item
+}
+
+// lookup returns the live entry for key, lazily deleting it if it has
+// expired. The caller must hold sh.mu for writing.
+func (s *KeyValueStore) lookup(sh *shard, key string) *QueueChannel {
+	item, exists := sh.store[key]
 	if !exists {
-		return "", false
+		return nil
 	}
+	if item.expired(time.Now()) {
+		s.remove(sh, key)
+		s.record(Change{Op: EventExpired, Key: key})
+		return nil
+	}
+	return item
+}
 
-	if item.queue[0].expiration != nil && time.Now().After(*item.queue[0].expiration) {
-		delete(s.Store, key)
-		return "", false
+func (s *KeyValueStore) Get(key string) (string, bool) {
+	val, _, ok := s.GetWithVersion(key)
+	return val, ok
+}
+
+// GetWithVersion is Get, also returning the key's current version. Reads
+// only take the shard's read lock, unless the key turns out to have expired
+// and has to be deleted.
+func (s *KeyValueStore) GetWithVersion(key string) (string, uint64, bool) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	item, exists := sh.store[key]
+	if !exists || !item.expired(time.Now()) {
+		defer sh.mu.RUnlock()
+
+		if item == nil || len(item.queue) == 0 {
+			s.misses.Add(1)
+			return "", 0, false
+		}
+		s.hits.Add(1)
+		s.access(item)
+		return item.queue[0].value, item.version, true
 	}
+	sh.mu.RUnlock()
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	return item.queue[0].value, true
+	item = s.lookup(sh, key)
+	if item == nil || len(item.queue) == 0 {
+		s.misses.Add(1)
+		return "", 0, false
+	}
+	s.hits.Add(1)
+	s.access(item)
+	return item.queue[0].value, item.version, true
+}
+
+// Mget reads several keys as one atomic step, holding the read locks of
+// every shard involved. found[i] reports whether keys[i] exists.
+func (s *KeyValueStore) Mget(keys []string) (values []string, found []bool) {
+	shards := s.shardsFor(keys)
+	rlockShards(shards)
+	defer runlockShards(shards)
+
+	values = make([]string, len(keys))
+	found = make([]bool, len(keys))
+	for i, key := range keys {
+		item := peek(s.shardFor(key), key)
+		if item == nil || len(item.queue) == 0 {
+			s.misses.Add(1)
+			continue
+		}
+		s.hits.Add(1)
+		s.access(item)
+		values[i], found[i] = item.queue[0].value, true
+	}
+	return values, found
+}
+
+// Mset sets keys[i] to values[i] for every i as one atomic step.
+func (s *KeyValueStore) Mset(keys, values []string, expiration int) error {
+	shards := s.shardsFor(keys)
+	lockShards(shards)
+	defer unlockShards(shards)
+
+	if err := s.reserve(s.batchWrites(keys, values), shards...); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		s.setLocked(s.shardFor(key), key, values[i], seconds(expiration))
+	}
+	return nil
+}
+
+// Msetnx is like Mset but sets nothing at all if any of the keys exists.
+func (s *KeyValueStore) Msetnx(keys, values []string, expiration int) (bool, error) {
+	shards := s.shardsFor(keys)
+	lockShards(shards)
+	defer unlockShards(shards)
+
+	for _, key := range keys {
+		if s.lookup(s.shardFor(key), key) != nil {
+			return false, nil
+		}
+	}
+	if err := s.reserve(s.batchWrites(keys, values), shards...); err != nil {
+		return false, err
+	}
+	for i, key := range keys {
+		s.setLocked(s.shardFor(key), key, values[i], seconds(expiration))
+	}
+	return true, nil
+}
+
+// batchWrites estimates the writes of the given keys and values.
+func (s *KeyValueStore) batchWrites(keys, values []string) []write {
+	writes := make([]write, len(keys))
+	for i, key := range keys {
+		writes[i] = s.writeOf(key, values[i])
+	}
+	return writes
+}
+
+// Del removes the given keys and returns how many of them existed.
+func (s *KeyValueStore) Del(keys []string) int {
+	shards := s.shardsFor(keys)
+	lockShards(shards)
+	defer unlockShards(shards)
+
+	deleted := 0
+	for _, key := range keys {
+		sh := s.shardFor(key)
+		if s.lookup(sh, key) == nil {
+			continue
+		}
+		s.remove(sh, key)
+		s.record(Change{Op: EventDel, Key: key})
+		deleted++
+	}
+	return deleted
 }
 
 func (s *KeyValueStore) Qpush(key string, values []string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if item := s.lookup(sh, key); item != nil && item.kind == TypeString {
+		return ErrWrongType
+	}
+	if err := s.reserve([]write{s.writeOf(key, values...)}, sh); err != nil {
+		return err
+	}
 
 	for _, val := range values {
-		exp := time.Now().Add(24 * time.Hour)
-		item := &KeyValueItem{value: val, expiration: &exp}
+		item := &KeyValueItem{value: val}
+		if ttl := s.QueueTTL(); ttl > 0 {
+			exp := time.Now().Add(ttl)
+			item.expiration = &exp
+		}
 
-		if _, exists := s.Store[key]; exists {
+		if _, exists := sh.store[key]; exists {
 			select {
-				case s.Store[key].channel <- val: // send the value to the channel
-					s.Store[key].queue = append(s.Store[key].queue, item)
+				case sh.store[key].channel <- val: // send the value to the channel
+					sh.store[key].queue = append(sh.store[key].queue, item)
 				default:
-					s.Store[key].queue = append(s.Store[key].queue, item)
+					sh.store[key].queue = append(sh.store[key].queue, item)
 			}
+			s.touch(sh.store[key])
+			s.resize(sh.store[key], itemSize(val))
 		} else {
-			channel := make(chan string, 25)
-			s.Store[key] = &QueueChannel{
+			channel := make(chan string, s.QueueBuffer())
+			s.insert(sh, key, &QueueChannel{
+				kind:    TypeQueue,
 				queue:   []*KeyValueItem{item},
 				channel: channel,
-			}
+			})
+			s.touch(sh.store[key])
+			s.resize(sh.store[key], baseSize(key, sh.store[key])+itemSize(val))
 			select {
-				case s.Store[key].channel <- val: // send the value to the channel
-					// s.Store[key].queue = append(s.Store[key].queue, item)
-				default:
-					s.Store[key].queue = append(s.Store[key].queue, item)
+				case sh.store[key].channel <- val: // send the value to the channel
+				default: // the value is queued already
 			}
 		}
 	}
+	s.access(sh.store[key])
+	s.record(Change{Op: EventQpush, Key: key, Values: append([]string(nil), values...)})
+	sh.wakePoppers()
 
 	return nil
 }
 
 func (s *KeyValueStore) Qpop(key string) (string, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	item, exists := s.Store[key]
+	item, exists := sh.store[key]
 	if !exists {
 		return "key not found", false
 	}
@@ -111,62 +404,103 @@ func (s *KeyValueStore) Qpop(key string) (string, bool) {
 
 	val := item.queue[n-1].value
 	item.queue = item.queue[:n-1]
+	s.touch(item)
+	s.access(item)
+	s.resize(item, -itemSize(val))
+	s.record(Change{Op: EventQpop, Key: key, Values: []string{val}})
 
 	return val, true
 }
 
 func (s *KeyValueStore) Bqpop(key string, timeout time.Duration) (string) {
-	s.mu.Lock()
+	val, _ := s.BqpopContext(context.Background(), key, timeout)
+	return val
+}
 
-	resultChan := make(chan string, 1)
-	go func(key string) {
-		time.Sleep(timeout)
-		item, exists := s.Store[key]
+// BqpopContext pops the oldest value of the queue at key, waiting up to
+// timeout for one to be pushed. It returns "" if none was, and ctx's error
+// if ctx is done first.
+func (s *KeyValueStore) BqpopContext(ctx context.Context, key string, timeout time.Duration) (string, error) {
+	wait, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-		if !exists {
-			resultChan <- "" // "key not found
-			return
-		}
+	val, err := s.waitPop(wait, key, nil)
+	if err != nil && ctx.Err() == nil {
+		return "", nil // timed out, or key holds a string
+	}
+	return val, err
+}
 
-		n := len(item.queue)
-		if n == 0 {
-			resultChan <- "" // "queue is empty"
-			return 
+// waitPop pops the oldest value of the queue at key, waiting for a push
+// while there is none. It gives up with ctx's error once ctx is done, and
+// with ErrClosed once stop, if not nil, is closed.
+func (s *KeyValueStore) waitPop(ctx context.Context, key string, stop <-chan struct{}) (string, error) {
+	sh := s.shardFor(key)
+	for {
+		sh.mu.Lock()
+		val, err := s.popTyped(sh, key)
+		if err != ErrNotFound {
+			sh.mu.Unlock()
+			return val, err
 		}
+		pushed := sh.pushWaiter()
+		sh.mu.Unlock()
 
+		s.blocked.Add(1)
 		select {
-			case val := <-item.channel:
-				item.queue = item.queue[1:]   // If you wanna pop from front of the queue, use this line
-				// item.queue = item.queue[:n-1] // If you wanna pop from back of the queue, use this line
-				resultChan <- val
-				return
-			default:
-				val := item.queue[0].value // If you wanna pop from front of the queue, use this line
-				item.queue = item.queue[1:]
-			// val := item.queue[n-1].value  // If you wanna pop from back of the queue, use this line
-			// item.queue = item.queue[:n-1]
-				resultChan <- val
-				return
+		case <-pushed:
+			s.blocked.Add(-1)
+		case <-ctx.Done():
+			s.blocked.Add(-1)
+			return "", ctx.Err()
+		case <-stop:
+			s.blocked.Add(-1)
+			return "", ErrClosed
 		}
-	}(key)
-
-	s.mu.Unlock()	
-	
-	popVal := <- resultChan
-	return popVal
-}
-
-
-// func (s *KeyValueStore) StartCleanupLoop(intervalSeconds int) {
-// 	ticker := time.NewTicker(time.Duration(intervalSeconds) * time.Second)
-// 	for {
-// 		<-ticker.C
-// 		s.mu.Lock()
-// 		for key, item := range s.Store {
-// 			if item.queue[0].expiration != nil && time.Now().After(*item.queue[0].expiration) {
-// 				delete(s.Store, key)
-// 			}
-// 		} 
-// 		s.mu.Unlock()
-// 	}
-// }
\ No newline at end of file
+	}
+}
+
+// popFront removes and returns the oldest value of the queue at key. The
+// caller must hold sh.mu for writing.
+func (s *KeyValueStore) popFront(sh *shard, key string) (string, bool) {
+	item, exists := sh.store[key]
+	if !exists || len(item.queue) == 0 {
+		return "", false
+	}
+
+	var val string
+	select {
+		case val = <-item.channel:
+		default:
+			val = item.queue[0].value
+	}
+	s.resize(item, -itemSize(item.queue[0].value))
+	item.queue = item.queue[1:]
+	s.touch(item)
+	s.access(item)
+	s.record(Change{Op: EventQpop, Key: key, Values: []string{val}})
+	return val, true
+}
+
+// DeleteExpired deletes every key that has expired and returns how many were
+// deleted. Expired keys are otherwise only deleted when they are next looked
+// up, so this bounds the memory held by keys nobody reads again.
+func (s *KeyValueStore) DeleteExpired() int {
+	now := time.Now()
+	deleted := 0
+	for _, sh := range s.shardList() {
+		sh.mu.Lock()
+		for key, item := range sh.store {
+			if item.expired(now) {
+				s.remove(sh, key)
+				s.record(Change{Op: EventExpired, Key: key})
+				deleted++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	if deleted > 0 {
+		s.log().Debug("expired keys deleted", "count", deleted)
+	}
+	return deleted
+}
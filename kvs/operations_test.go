@@ -1,11 +1,12 @@
 package kvs
 
 import (
-    "testing"
+	"testing"
 	"time"
 )
+
 func TestSetAndGet(t *testing.T) {
-	kvs := KeyValueStore{Store: make(map[string]*QueueChannel)}
+	kvs := NewKeyValueStore()
 
 	key := "test_key"
 	value := "test_value"
@@ -50,7 +51,7 @@ func TestSetAndGet(t *testing.T) {
 }
 
 func BenchmarkSetAndGet(b *testing.B) {
-	kvs := KeyValueStore{Store: make(map[string]*QueueChannel)}
+	kvs := NewKeyValueStore()
 	key := "test_key"
 	value := "test_value"
 	expiration := 10
@@ -62,7 +63,7 @@ func BenchmarkSetAndGet(b *testing.B) {
 }
 
 func TestQueueOperations(t *testing.T) {
-	kvs := KeyValueStore{Store: make(map[string]*QueueChannel)}
+	kvs := NewKeyValueStore()
 
 	key := "test_queue"
 
@@ -133,25 +134,25 @@ func TestQueueOperations(t *testing.T) {
 }
 
 func BenchmarkQpush(b *testing.B) {
-    kvs := KeyValueStore{Store: make(map[string]*QueueChannel)}
-
-    key := "test_queue"
-    // Push values to the queue
-    values := []string{"value1", "value2", "value3"}
-    if err := kvs.Qpush(key, values); err != nil {
-        b.Errorf("Qpush() FAILED: to push values to the queue")
-    }
+	kvs := NewKeyValueStore()
+
+	key := "test_queue"
+	// Push values to the queue
+	values := []string{"value1", "value2", "value3"}
+	if err := kvs.Qpush(key, values); err != nil {
+		b.Errorf("Qpush() FAILED: to push values to the queue")
+	}
 }
 
 func BenchmarkQpop(b *testing.B) {
-    kvs := KeyValueStore{Store: make(map[string]*QueueChannel)}
-    key := "test_queue"
-    // Benchmark Qpop
-	for i := 0; i < b.N; i++ {	
-    	values := []string{"value1", "value2", "value3"}
+	kvs := NewKeyValueStore()
+	key := "test_queue"
+	// Benchmark Qpop
+	for i := 0; i < b.N; i++ {
+		values := []string{"value1", "value2", "value3"}
 		if err := kvs.Qpush(key, values); err != nil {
-    	    b.Errorf("Qpush() FAILED: to push values to the queue")
-    	}
+			b.Errorf("Qpush() FAILED: to push values to the queue")
+		}
 
 		val, ok := kvs.Qpop(key)
 		if val != "value3" {
@@ -164,7 +165,7 @@ func BenchmarkQpop(b *testing.B) {
 }
 
 func BenchmarkBqpop(b *testing.B) {
-	kvs := KeyValueStore{Store: make(map[string]*QueueChannel)}
+	kvs := NewKeyValueStore()
 
 	key := "test_queue"
 	values := []string{"value1", "value2", "value3"}
@@ -175,4 +176,114 @@ func BenchmarkBqpop(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		kvs.Bqpop(key, time.Second)
 	}
-}
\ No newline at end of file
+}
+
+func TestBatchOperations(t *testing.T) {
+	kvs := NewKeyValueStore()
+
+	kvs.Mset([]string{"k1", "k2"}, []string{"v1", "v2"}, 0)
+
+	values, found := kvs.Mget([]string{"k1", "missing", "k2"})
+	if !found[0] || values[0] != "v1" || found[1] || !found[2] || values[2] != "v2" {
+		t.Errorf("Mget() FAILED: expected [v1 <nil> v2], but got %v %v", values, found)
+	}
+
+	if ok, _ := kvs.Msetnx([]string{"k3", "k1"}, []string{"v3", "new"}, 0); ok {
+		t.Errorf("Msetnx() FAILED: must return false when any key already exists")
+	}
+	if _, ok := kvs.Get("k3"); ok {
+		t.Errorf("Msetnx() FAILED: must not set any key when one already exists")
+	}
+	if ok, err := kvs.Msetnx([]string{"k3", "k4"}, []string{"v3", "v4"}, 0); !ok || err != nil {
+		t.Errorf("Msetnx() FAILED: must set all keys when none exists")
+	}
+	if val, ok := kvs.Get("k4"); !ok || val != "v4" {
+		t.Errorf("Msetnx() FAILED: expected v4, but got %v", val)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	kvs := NewKeyValueStore()
+
+	if v := kvs.Version("key"); v != 0 {
+		t.Errorf("Version() FAILED: expected 0 for a missing key, but got %v", v)
+	}
+
+	kvs.Set("key", "value", 0, "")
+	v1 := kvs.Version("key")
+	kvs.Get("key")
+	if v := kvs.Version("key"); v != v1 {
+		t.Errorf("Version() FAILED: reads must not change the version, expected %v, but got %v", v1, v)
+	}
+
+	kvs.Set("key", "other", 0, "")
+	v2 := kvs.Version("key")
+	if v2 <= v1 {
+		t.Errorf("Version() FAILED: expected a version above %v after a write, but got %v", v1, v2)
+	}
+
+	kvs.Qpush("queue", []string{"a"})
+	v3 := kvs.Version("queue")
+	kvs.Qpop("queue")
+	if v := kvs.Version("queue"); v <= v3 {
+		t.Errorf("Version() FAILED: expected a version above %v after Qpop, but got %v", v3, v)
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	kvs := NewKeyValueStore()
+
+	v1, ok, _ := kvs.CompareAndSet("key", "first", 0, 0)
+	if !ok {
+		t.Fatalf("CompareAndSet() FAILED: version 0 must create a missing key")
+	}
+	if _, ok, _ := kvs.CompareAndSet("key", "again", 0, 0); ok {
+		t.Errorf("CompareAndSet() FAILED: version 0 must fail when the key exists")
+	}
+
+	v2, ok, _ := kvs.CompareAndSet("key", "second", 0, v1)
+	if !ok || v2 <= v1 {
+		t.Errorf("CompareAndSet() FAILED: expected a new version above %v, but got %v, %v", v1, v2, ok)
+	}
+	current, ok, _ := kvs.CompareAndSet("key", "stale", 0, v1)
+	if ok || current != v2 {
+		t.Errorf("CompareAndSet() FAILED: expected a mismatch reporting %v, but got %v, %v", v2, current, ok)
+	}
+
+	val, version, ok := kvs.GetWithVersion("key")
+	if !ok || val != "second" || version != v2 {
+		t.Errorf("GetWithVersion() FAILED: expected second at %v, but got %v at %v", v2, val, version)
+	}
+}
+
+func TestSetWithoutExpiration(t *testing.T) {
+	kvs := NewKeyValueStore()
+	for key, expiration := range map[string]int{"zero": 0, "negative": -1} {
+		if !kvs.Set(key, "value", expiration, "") {
+			t.Fatalf("Set() FAILED: must set %v", key)
+		}
+		if _, ok := kvs.Get(key); !ok {
+			t.Errorf("Get() FAILED: expected %v, set with expiration %d, to never expire", key, expiration)
+		}
+		if item := peek(kvs.shardFor(key), key); item == nil || item.queue[0].expiration != nil {
+			t.Errorf("Set() FAILED: expected %v to have no expiration", key)
+		}
+	}
+}
+
+func TestQueueBufferZero(t *testing.T) {
+	kvs := NewKeyValueStore()
+	if err := kvs.SetQueueBuffer(0); err == nil {
+		t.Errorf("SetQueueBuffer() FAILED: expected an error for a buffer of 0")
+	}
+
+	// A full channel must not queue the value a second time.
+	kvs.queueBuffer.Store(0)
+	kvs.Qpush("queue", []string{"a"})
+	if val, ok := kvs.Qpop("queue"); !ok || val != "a" {
+		t.Errorf("Qpop() FAILED: expected a, but got %v", val)
+	}
+	if val, ok := kvs.Qpop("queue"); ok {
+		t.Errorf("Qpop() FAILED: expected an empty queue, but got %v", val)
+	}
+}
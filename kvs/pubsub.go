@@ -0,0 +1,158 @@
+package kvs
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrSlowConsumer is reported by a Subscription that was dropped because its
+// buffer filled up. Publishers never wait for subscribers.
+var ErrSlowConsumer = errors.New("subscriber disconnected: too slow to keep up")
+
+// Message is a published message as delivered to a subscriber. Pattern is
+// set when the message matched a pattern subscription.
+type Message struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Payload string `json:"message"`
+}
+
+// PubSub fans published messages out to subscribers. Delivery is fire and
+// forget: messages published while nobody is listening are dropped.
+type PubSub struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the messages of a set of channels and patterns on C
+// until it is closed, either by Unsubscribe or because it fell behind.
+type Subscription struct {
+	C <-chan Message
+
+	c        chan Message
+	channels map[string]bool
+	patterns []string
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// Done is closed once the subscription stops receiving messages. Messages
+// still buffered on C may be drained after that.
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Err returns why the subscription was closed, or nil if it was closed by
+// Unsubscribe or is still open.
+func (sub *Subscription) Err() error {
+	select {
+	case <-sub.done:
+		return sub.err
+	default:
+		return nil
+	}
+}
+
+func (sub *Subscription) close(err error) {
+	sub.once.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+// matches returns the pattern, if any, through which sub receives messages
+// published on channel.
+func (sub *Subscription) matches(channel string) (string, bool) {
+	if sub.channels[channel] {
+		return "", true
+	}
+	for _, pattern := range sub.patterns {
+		if MatchPattern(pattern, channel) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Subscribe listens on the given channels and glob patterns. At most buffer
+// messages are held for the subscriber; one more and it is disconnected.
+func (p *PubSub) Subscribe(channels, patterns []string, buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	c := make(chan Message, buffer)
+	sub := &Subscription{
+		C:        c,
+		c:        c,
+		channels: make(map[string]bool, len(channels)),
+		patterns: patterns,
+		done:     make(chan struct{}),
+	}
+	for _, channel := range channels {
+		sub.channels[channel] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops delivery to sub and closes it.
+func (p *PubSub) Unsubscribe(sub *Subscription) {
+	p.mu.Lock()
+	delete(p.subs, sub)
+	p.mu.Unlock()
+
+	sub.close(nil)
+}
+
+// Publish sends message to every subscriber of channel and returns how many
+// received it. Subscribers whose buffer is full are disconnected.
+func (p *PubSub) Publish(channel, message string) int {
+	var slow []*Subscription
+	receivers := 0
+
+	p.mu.RLock()
+	for sub := range p.subs {
+		pattern, ok := sub.matches(channel)
+		if !ok {
+			continue
+		}
+		select {
+		case sub.c <- Message{Channel: channel, Pattern: pattern, Payload: message}:
+			receivers++
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	p.mu.RUnlock()
+
+	if len(slow) > 0 {
+		p.mu.Lock()
+		for _, sub := range slow {
+			delete(p.subs, sub)
+		}
+		p.mu.Unlock()
+
+		for _, sub := range slow {
+			sub.close(ErrSlowConsumer)
+		}
+	}
+	return receivers
+}
+
+// NumSubscribers returns the number of open subscriptions.
+func (p *PubSub) NumSubscribers() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.subs)
+}
@@ -0,0 +1,56 @@
+package kvs
+
+import "testing"
+
+func TestPubSub(t *testing.T) {
+	ps := NewPubSub()
+
+	direct := ps.Subscribe([]string{"news"}, nil, 10)
+	pattern := ps.Subscribe(nil, []string{"n*"}, 10)
+	other := ps.Subscribe([]string{"sports"}, nil, 10)
+
+	if n := ps.Publish("news", "hello world"); n != 2 {
+		t.Errorf("Publish() FAILED: expected 2 receivers, but got %v", n)
+	}
+	if msg := <-direct.C; msg.Channel != "news" || msg.Payload != "hello world" || msg.Pattern != "" {
+		t.Errorf("Subscribe() FAILED: unexpected message %+v", msg)
+	}
+	if msg := <-pattern.C; msg.Channel != "news" || msg.Pattern != "n*" {
+		t.Errorf("Subscribe() FAILED: unexpected pattern message %+v", msg)
+	}
+	if len(other.C) != 0 {
+		t.Errorf("Subscribe() FAILED: sports subscriber must not receive news")
+	}
+
+	ps.Unsubscribe(direct)
+	if n := ps.Publish("news", "again"); n != 1 {
+		t.Errorf("Unsubscribe() FAILED: expected 1 receiver, but got %v", n)
+	}
+	if direct.Err() != nil {
+		t.Errorf("Unsubscribe() FAILED: expected no error, but got %v", direct.Err())
+	}
+}
+
+func TestPubSubSlowConsumer(t *testing.T) {
+	ps := NewPubSub()
+	sub := ps.Subscribe([]string{"events"}, nil, 2)
+
+	for i := 0; i < 3; i++ {
+		ps.Publish("events", "message")
+	}
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatalf("Publish() FAILED: a full subscriber must be disconnected")
+	}
+	if sub.Err() != ErrSlowConsumer {
+		t.Errorf("Publish() FAILED: expected %v, but got %v", ErrSlowConsumer, sub.Err())
+	}
+	if n := ps.NumSubscribers(); n != 0 {
+		t.Errorf("Publish() FAILED: expected no subscribers left, but got %v", n)
+	}
+	if len(sub.C) != 2 {
+		t.Errorf("Publish() FAILED: buffered messages must stay readable, but got %v", len(sub.C))
+	}
+}
@@ -0,0 +1,168 @@
+package kvs
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// DefaultScanCount is the number of keys SCAN aims to return per call when
+// no COUNT is given.
+const DefaultScanCount = 10
+
+// keyHash places a key on the cursor space walked by Scan.
+func keyHash(key string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return uint64(h.Sum32())
+}
+
+// scanCandidate is a key Scan may return.
+type scanCandidate struct {
+	hash uint64
+	key  string
+	kind string
+}
+
+// less orders candidates by hash, then by key.
+func (c scanCandidate) less(o scanCandidate) bool {
+	if c.hash != o.hash {
+		return c.hash < o.hash
+	}
+	return c.key < o.key
+}
+
+// scanHeap is a max-heap of candidates, the largest first.
+type scanHeap []scanCandidate
+
+func (h scanHeap) Len() int           { return len(h) }
+func (h scanHeap) Less(i, j int) bool { return h[j].less(h[i]) }
+func (h scanHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x any)        { *h = append(*h, x.(scanCandidate)) }
+func (h *scanHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// Type returns the type of the value stored at key, or TypeNone.
+func (s *KeyValueStore) Type(key string) string {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item := peek(sh, key)
+	if item == nil {
+		return TypeNone
+	}
+	return item.kind
+}
+
+// Scan walks the keyspace incrementally. Keys are visited in order of their
+// hash, and the returned cursor is the hash to resume from, so keys that stay
+// in the store for the whole iteration are returned at least once no matter
+// how many other keys are added or removed in between. A returned cursor of
+// 0 means the iteration is complete.
+//
+// count is a hint: all keys that share the hash of the last returned key are
+// included, so a page may be slightly larger. pattern and kind filter the
+// page after it has been selected, so a page may also be smaller, or empty,
+// even though the iteration is not finished.
+func (s *KeyValueStore) Scan(cursor uint64, pattern string, count int, kind string) (uint64, []string) {
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	// Only the count smallest candidates are kept, in a max-heap, so a page
+	// costs O(n log count) rather than sorting the whole keyspace.
+	now := time.Now()
+	page := make(scanHeap, 0, count)
+	var ties []scanCandidate // dropped with the hash of the largest kept
+	dropped, maxDropped := false, uint64(0)
+	drop := func(c scanCandidate) {
+		if len(page) > 0 && c.hash == page[0].hash {
+			ties = append(ties, c)
+		}
+		if !dropped || c.hash > maxDropped {
+			dropped, maxDropped = true, c.hash
+		}
+	}
+	for _, sh := range s.shardList() {
+		sh.mu.RLock()
+		for key, item := range sh.store {
+			if item.expired(now) {
+				continue
+			}
+			h := keyHash(key)
+			if h < cursor {
+				continue
+			}
+			c := scanCandidate{hash: h, key: key, kind: item.kind}
+			switch {
+			case len(page) < count:
+				heap.Push(&page, c)
+			case c.less(page[0]):
+				top := page[0]
+				page[0] = c
+				heap.Fix(&page, 0)
+				drop(top)
+			default:
+				drop(c)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	// Keys sharing the hash of the last one kept all go on this page, as the
+	// next one resumes from the hash after it.
+	candidates := make([]scanCandidate, len(page), len(page)+len(ties))
+	copy(candidates, page)
+	next := uint64(0)
+	if dropped {
+		last := page[0].hash
+		for _, c := range ties {
+			if c.hash == last {
+				candidates = append(candidates, c)
+			}
+		}
+		if maxDropped > last {
+			next = last + 1
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].less(candidates[j]) })
+
+	keys := []string{}
+	for _, c := range candidates {
+		if pattern != "" && !MatchPattern(pattern, c.key) {
+			continue
+		}
+		if kind != "" && c.kind != kind {
+			continue
+		}
+		keys = append(keys, c.key)
+	}
+	return next, keys
+}
+
+// Keys returns every live key matching pattern, sorted. It walks every shard
+// and is meant for small datasets; use Scan otherwise.
+func (s *KeyValueStore) Keys(pattern string) []string {
+	now := time.Now()
+	keys := []string{}
+	for _, sh := range s.shardList() {
+		sh.mu.RLock()
+		for key, item := range sh.store {
+			if item.expired(now) {
+				continue
+			}
+			if pattern == "" || MatchPattern(pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	sort.Strings(keys)
+	return keys
+}
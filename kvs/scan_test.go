@@ -0,0 +1,103 @@
+package kvs
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	kvs := NewKeyValueStore()
+
+	for i := 0; i < 100; i++ {
+		kvs.Set(fmt.Sprintf("user:%d", i), "value", 0, "")
+	}
+	kvs.Qpush("queue:1", []string{"a", "b"})
+
+	seen := map[string]int{}
+	cursor := uint64(0)
+	for page := 0; ; page++ {
+		next, keys := kvs.Scan(cursor, "user:*", 7, "")
+		for _, key := range keys {
+			seen[key]++
+		}
+
+		// Keys added and removed mid-iteration must not disturb the others.
+		kvs.Set(fmt.Sprintf("late:%d", page), "value", 0, "")
+		kvs.Del([]string{fmt.Sprintf("late:%d", page-1)})
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	if len(seen) != 100 {
+		t.Errorf("Scan() FAILED: expected 100 keys, but got %v", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("Scan() FAILED: expected %v once, but got it %v times", key, n)
+		}
+	}
+
+	_, keys := kvs.Scan(0, "", 1000, TypeQueue)
+	if len(keys) != 1 || keys[0] != "queue:1" {
+		t.Errorf("Scan() FAILED: expected only queue:1 for TYPE queue, but got %v", keys)
+	}
+}
+
+func TestScanHashCollisions(t *testing.T) {
+	// Find two keys with the same hash; with 32-bit hashes it takes about
+	// 2^16 tries.
+	byHash := map[uint64]string{}
+	var a, b string
+	for i := 0; a == ""; i++ {
+		key := fmt.Sprintf("k%d", i)
+		h := keyHash(key)
+		if other, ok := byHash[h]; ok {
+			a, b = other, key
+		}
+		byHash[h] = key
+	}
+
+	kvs := NewKeyValueStore()
+	kvs.Set(a, "1", 0, "")
+	kvs.Set(b, "2", 0, "")
+	for i := 0; i < 50; i++ {
+		kvs.Set(fmt.Sprintf("other:%d", i), "value", 0, "")
+	}
+
+	seen := map[string]int{}
+	for cursor := uint64(0); ; {
+		next, keys := kvs.Scan(cursor, "", 1, "")
+		for _, key := range keys {
+			seen[key]++
+		}
+		if seen[a] != seen[b] {
+			t.Fatalf("Scan() FAILED: expected %v and %v on the same page, but got %v", a, b, keys)
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 52 {
+		t.Errorf("Scan() FAILED: expected 52 keys, but got %v", len(seen))
+	}
+}
+
+func TestKeys(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.Set("hello", "1", 0, "")
+	kvs.Set("hallo", "2", 0, "")
+	kvs.Set("world", "3", 0, "")
+
+	keys := kvs.Keys("h?llo")
+	if !sort.StringsAreSorted(keys) || len(keys) != 2 || keys[0] != "hallo" || keys[1] != "hello" {
+		t.Errorf("Keys() FAILED: expected [hallo hello], but got %v", keys)
+	}
+	if keys := kvs.Keys("nothing*"); len(keys) != 0 {
+		t.Errorf("Keys() FAILED: expected no keys, but got %v", keys)
+	}
+}
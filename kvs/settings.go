@@ -0,0 +1,65 @@
+package kvs
+
+import (
+	"errors"
+	"time"
+)
+
+// Defaults of the settings below for a new store.
+const (
+	DefaultSetTTL      = 99999 * time.Second
+	DefaultQueueTTL    = 24 * time.Hour
+	DefaultQueueBuffer = 25
+)
+
+// SetDefaultTTL sets the time-to-live of keys SET without an expiration. 0
+// makes them never expire. Keys already stored keep their expiration.
+func (s *KeyValueStore) SetDefaultTTL(d time.Duration) error {
+	s.lazyInit()
+	if d < 0 {
+		return errors.New("invalid default TTL")
+	}
+	s.defaultTTL.Store(int64(d))
+	return nil
+}
+
+// DefaultTTL returns the time-to-live of keys SET without an expiration.
+func (s *KeyValueStore) DefaultTTL() time.Duration {
+	s.lazyInit()
+	return time.Duration(s.defaultTTL.Load())
+}
+
+// SetQueueTTL sets the time-to-live of each value pushed to a queue. 0 makes
+// them never expire.
+func (s *KeyValueStore) SetQueueTTL(d time.Duration) error {
+	s.lazyInit()
+	if d < 0 {
+		return errors.New("invalid queue TTL")
+	}
+	s.queueTTL.Store(int64(d))
+	return nil
+}
+
+// QueueTTL returns the time-to-live of each value pushed to a queue.
+func (s *KeyValueStore) QueueTTL() time.Duration {
+	s.lazyInit()
+	return time.Duration(s.queueTTL.Load())
+}
+
+// SetQueueBuffer sets the capacity of the channel of entries created from
+// now on, which holds the values waiting for a blocking pop. It must be at
+// least 1.
+func (s *KeyValueStore) SetQueueBuffer(n int) error {
+	s.lazyInit()
+	if n < 1 {
+		return errors.New("invalid queue buffer size")
+	}
+	s.queueBuffer.Store(int64(n))
+	return nil
+}
+
+// QueueBuffer returns the channel capacity of new entries.
+func (s *KeyValueStore) QueueBuffer() int {
+	s.lazyInit()
+	return int(s.queueBuffer.Load())
+}
@@ -0,0 +1,139 @@
+package kvs
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultShards is the number of shards used by NewKeyValueStore.
+const DefaultShards = 32
+
+// shard is an independently locked part of the keyspace. A key always lives
+// in the shard picked by its hash, see shardFor.
+type shard struct {
+	mu    sync.RWMutex
+	index int
+	store map[string]*QueueChannel
+
+	// pushed is closed on the next push to any queue of the shard, waking
+	// blocked poppers. It is only created once someone waits on it.
+	pushed chan struct{}
+}
+
+// pushWaiter returns a channel that is closed on the next push to the shard.
+// The caller must hold sh.mu for writing.
+func (sh *shard) pushWaiter() <-chan struct{} {
+	if sh.pushed == nil {
+		sh.pushed = make(chan struct{})
+	}
+	return sh.pushed
+}
+
+// wakePoppers wakes everyone waiting on pushWaiter. The caller must hold
+// sh.mu for writing.
+func (sh *shard) wakePoppers() {
+	if sh.pushed != nil {
+		close(sh.pushed)
+		sh.pushed = nil
+	}
+}
+
+// NewKeyValueStore returns an empty store split into DefaultShards shards.
+func NewKeyValueStore() *KeyValueStore {
+	return NewShardedStore(DefaultShards)
+}
+
+// NewShardedStore returns an empty store split into n shards. Operations on
+// keys in different shards never wait for each other, so more shards allow
+// more parallelism at the cost of slower whole-keyspace walks.
+func NewShardedStore(n int) *KeyValueStore {
+	s := &KeyValueStore{}
+	s.setup(n)
+	return s
+}
+
+// setup splits the store into n shards and applies the default settings.
+func (s *KeyValueStore) setup(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.shards = make([]*shard, n)
+	s.defaultTTL.Store(int64(DefaultSetTTL))
+	s.queueTTL.Store(int64(DefaultQueueTTL))
+	s.queueBuffer.Store(DefaultQueueBuffer)
+	for i := range s.shards {
+		s.shards[i] = &shard{index: i, store: make(map[string]*QueueChannel)}
+	}
+}
+
+// lazyInit sets up a store created as a zero value as NewKeyValueStore
+// would, the first time it is used. Every method reaches it, through
+// shardList or the settings, before touching the shards or the settings.
+func (s *KeyValueStore) lazyInit() {
+	s.initOnce.Do(func() {
+		if s.shards == nil {
+			s.setup(DefaultShards)
+		}
+	})
+}
+
+// shardList returns the shards of the store, setting it up if needed.
+func (s *KeyValueStore) shardList() []*shard {
+	s.lazyInit()
+	return s.shards
+}
+
+func (s *KeyValueStore) shardFor(key string) *shard {
+	shards := s.shardList()
+	return shards[keyHash(key)%uint64(len(shards))]
+}
+
+// shardsFor returns the distinct shards holding keys, ordered by index.
+// Multi-key operations lock them in this order so they can never deadlock
+// with each other.
+func (s *KeyValueStore) shardsFor(keys []string) []*shard {
+	seen := make(map[*shard]bool, len(keys))
+	shards := make([]*shard, 0, len(keys))
+	for _, key := range keys {
+		sh := s.shardFor(key)
+		if !seen[sh] {
+			seen[sh] = true
+			shards = append(shards, sh)
+		}
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].index < shards[j].index })
+	return shards
+}
+
+func lockShards(shards []*shard) {
+	for _, sh := range shards {
+		sh.mu.Lock()
+	}
+}
+
+func unlockShards(shards []*shard) {
+	for _, sh := range shards {
+		sh.mu.Unlock()
+	}
+}
+
+func rlockShards(shards []*shard) {
+	for _, sh := range shards {
+		sh.mu.RLock()
+	}
+}
+
+func runlockShards(shards []*shard) {
+	for _, sh := range shards {
+		sh.mu.RUnlock()
+	}
+}
+
+func holds(shards []*shard, sh *shard) bool {
+	for _, held := range shards {
+		if held == sh {
+			return true
+		}
+	}
+	return false
+}
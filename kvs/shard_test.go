@@ -0,0 +1,137 @@
+package kvs
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestShardedConcurrency(t *testing.T) {
+	kvs := NewShardedStore(8)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				a, b := fmt.Sprintf("a:%d:%d", w, i), fmt.Sprintf("b:%d:%d", w, i)
+				if err := kvs.Mset([]string{a, b}, []string{"1", "2"}, 0); err != nil {
+					t.Error(err)
+					return
+				}
+				kvs.Get(a)
+				kvs.Mget([]string{b, a})
+				// Lock the same shards in the opposite key order as other
+				// workers do, which deadlocks without a fixed lock order.
+				kvs.Del([]string{b, a})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if keys := kvs.Keys("*"); len(keys) != 0 {
+		t.Errorf("Keys() FAILED: expected an empty store, but got %v keys", len(keys))
+	}
+	if used := kvs.UsedMemory(); used != 0 {
+		t.Errorf("UsedMemory() FAILED: expected 0, but got %v", used)
+	}
+}
+
+func TestShardedScan(t *testing.T) {
+	kvs := NewShardedStore(4)
+	for i := 0; i < 50; i++ {
+		kvs.Set("key:"+strconv.Itoa(i), "value", 0, "")
+	}
+
+	seen := map[string]bool{}
+	cursor := uint64(0)
+	for {
+		next, keys := kvs.Scan(cursor, "", 7, "")
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 50 {
+		t.Errorf("Scan() FAILED: expected 50 keys, but got %v", len(seen))
+	}
+}
+
+// Run with -cpu 1,2,4,8 to see how throughput scales with GOMAXPROCS. The
+// single shard variant serialises every operation like a store-wide lock.
+func BenchmarkGet(b *testing.B) {
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			kvs := NewShardedStore(shards)
+			for i := 0; i < 1024; i++ {
+				kvs.Set("key:"+strconv.Itoa(i), "value", 0, "")
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					kvs.Get("key:" + strconv.Itoa(i%1024))
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			kvs := NewShardedStore(shards)
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					kvs.Set("key:"+strconv.Itoa(i%1024), "value", 0, "")
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMixed(b *testing.B) {
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			kvs := NewShardedStore(shards)
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := "key:" + strconv.Itoa(i%1024)
+					if i%10 == 0 {
+						kvs.Set(key, "value", 0, "")
+					} else {
+						kvs.Get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestZeroValueStore(t *testing.T) {
+	var kvs KeyValueStore
+
+	if kvs.QueueBuffer() != DefaultQueueBuffer || kvs.DefaultTTL() != DefaultSetTTL {
+		t.Errorf("KeyValueStore{} FAILED: expected the default settings, but got %v %v", kvs.QueueBuffer(), kvs.DefaultTTL())
+	}
+	kvs.Set("key", "value", 0, "")
+	if val, ok := kvs.Get("key"); !ok || val != "value" {
+		t.Errorf("Get() FAILED: expected value, but got %v", val)
+	}
+	kvs.Qpush("queue", []string{"a"})
+	if val, ok := kvs.Qpop("queue"); !ok || val != "a" {
+		t.Errorf("Qpop() FAILED: expected a, but got %v", val)
+	}
+	if keys := kvs.Keys("*"); len(keys) != 2 {
+		t.Errorf("Keys() FAILED: expected [key queue], but got %v", keys)
+	}
+}
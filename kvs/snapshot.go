@@ -0,0 +1,155 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotEntry is one key of a snapshot. Snapshots are JSON lines, one
+// entry per line, so they can be written and read without holding the whole
+// keyspace in memory twice.
+type snapshotEntry struct {
+	Key   string         `json:"key"`
+	Type  string         `json:"type"`
+	Items []snapshotItem `json:"items"`
+}
+
+type snapshotItem struct {
+	Value   string     `json:"value"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Save writes every live key to w. Each shard is copied under its read lock,
+// so the snapshot is consistent per shard but not across shards.
+func (s *KeyValueStore) Save(w io.Writer) error {
+	version := s.version.Load()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, sh := range s.shardList() {
+		sh.mu.RLock()
+		entries := make([]snapshotEntry, 0, len(sh.store))
+		now := time.Now()
+		for key, item := range sh.store {
+			if item.expired(now) {
+				continue
+			}
+			entry := snapshotEntry{Key: key, Type: item.kind, Items: make([]snapshotItem, len(item.queue))}
+			for i, it := range item.queue {
+				entry.Items[i] = snapshotItem{Value: it.value, Expires: it.expiration}
+			}
+			entries = append(entries, entry)
+		}
+		sh.mu.RUnlock()
+
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	s.saved(version)
+	return nil
+}
+
+// saved records a save or load that reflects the store as of version.
+func (s *KeyValueStore) saved(version uint64) {
+	s.saveVersion.Store(version)
+	s.lastSave.Store(time.Now().Unix())
+}
+
+// Load adds the keys of a snapshot written by Save, replacing keys that
+// already exist. Items that expired in the meantime are skipped. Loading
+// bypasses the memory limit and publishes no notifications.
+func (s *KeyValueStore) Load(r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	now := time.Now()
+	for {
+		var entry snapshotEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			s.saved(s.version.Load())
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		item := &QueueChannel{kind: entry.Type, channel: make(chan string, s.QueueBuffer())}
+		for _, it := range entry.Items {
+			if it.Expires != nil && now.After(*it.Expires) {
+				continue
+			}
+			item.queue = append(item.queue, &KeyValueItem{value: it.Value, expiration: it.Expires})
+			if item.kind == TypeQueue {
+				select {
+				case item.channel <- it.Value: // mirrors the head of the queue, as in Qpush
+				default:
+				}
+			}
+		}
+		if len(item.queue) == 0 && item.kind == TypeString {
+			continue
+		}
+
+		sh := s.shardFor(entry.Key)
+		sh.mu.Lock()
+		s.remove(sh, entry.Key)
+		s.touch(item)
+		s.access(item)
+		s.insert(sh, entry.Key, item)
+		size := baseSize(entry.Key, item)
+		for _, it := range item.queue {
+			size += itemSize(it.value)
+		}
+		s.resize(item, size)
+		sh.mu.Unlock()
+	}
+}
+
+// SaveFile writes a snapshot to path. It is written to a temporary file
+// first and renamed into place, so a crash never leaves a partial snapshot.
+func (s *KeyValueStore) SaveFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if err := s.Save(tmp); err != nil {
+		tmp.Close()
+		s.log().Error("saving snapshot failed", "path", path, "err", err)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	s.log().Info("snapshot saved", "path", path)
+	return nil
+}
+
+// LoadFile loads the snapshot at path, see Load.
+func (s *KeyValueStore) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := s.Load(f); err != nil {
+		return err
+	}
+	s.log().Info("snapshot loaded", "path", path, "keys", s.Stats().Keys)
+	return nil
+}
@@ -0,0 +1,69 @@
+package kvs
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := NewKeyValueStore()
+	s.Set("string", "value", 100, "")
+	sh := s.shardFor("expired")
+	sh.mu.Lock()
+	s.setLocked(sh, "expired", "value", time.Millisecond)
+	sh.mu.Unlock()
+	s.Qpush("queue", []string{"a", "b"})
+	time.Sleep(10 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := s.Save(&buf); err != nil {
+		t.Fatalf("Save() FAILED: %v", err)
+	}
+
+	loaded := NewShardedStore(4)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load() FAILED: %v", err)
+	}
+	if val, _ := loaded.Get("string"); val != "value" {
+		t.Errorf("Get() FAILED: expected value, but got %q", val)
+	}
+	if loaded.Type("expired") != TypeNone {
+		t.Errorf("Type() FAILED: expected an expired key not to be saved, but got %s", loaded.Type("expired"))
+	}
+	if val, _ := loaded.Qpop("queue"); val != "b" {
+		t.Errorf("Qpop() FAILED: expected b, but got %q", val)
+	}
+	if loaded.Version("string") == 0 {
+		t.Errorf("Version() FAILED: expected a loaded key to have a version, but got 0")
+	}
+	if loaded.UsedMemory() == 0 {
+		t.Errorf("UsedMemory() FAILED: expected loaded keys to be accounted, but got 0")
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	s := NewKeyValueStore()
+	s.Set("key", "value", 100, "")
+
+	path := filepath.Join(t.TempDir(), "dump.jsonl")
+	if err := s.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile() FAILED: %v", err)
+	}
+	s.Set("key", "changed", 100, "")
+	if err := s.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile() FAILED: %v", err)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Errorf("SaveFile() FAILED: expected no temporary files left, but got %v", matches)
+	}
+
+	loaded := NewKeyValueStore()
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() FAILED: %v", err)
+	}
+	if val, _ := loaded.Get("key"); val != "changed" {
+		t.Errorf("Get() FAILED: expected changed, but got %q", val)
+	}
+}
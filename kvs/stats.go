@@ -0,0 +1,58 @@
+package kvs
+
+import "time"
+
+// Stats is a point-in-time summary of the store, for monitoring.
+type Stats struct {
+	Keys           map[string]int // live keys by type, TypeString or TypeQueue
+	Expires        int            // live keys with an expiration
+	QueueDepth     int            // values held across all queues
+	BlockedClients int            // callers waiting in a blocking pop
+	ExpiredKeys    uint64         // keys deleted on expiry since the store was created
+	EvictedKeys    uint64         // keys evicted to honour the memory limit
+	Hits           uint64         // reads by Get, Mget and DB.Get of a key that existed
+	Misses         uint64         // and of a key that did not
+	UsedMemory     int64
+	MaxMemory      int64
+
+	LastSave         time.Time // of the last snapshot saved or loaded, zero if none
+	ChangesSinceSave uint64    // writes since then
+}
+
+// Stats walks the keyspace, one shard at a time under its read lock, so its
+// cost grows with the number of keys.
+func (s *KeyValueStore) Stats() Stats {
+	st := Stats{
+		Keys:           map[string]int{TypeString: 0, TypeQueue: 0},
+		BlockedClients: int(s.blocked.Load()),
+		ExpiredKeys:    s.expiredKeys.Load(),
+		EvictedKeys:    s.evictedKeys.Load(),
+		Hits:           s.hits.Load(),
+		Misses:         s.misses.Load(),
+		UsedMemory:     s.UsedMemory(),
+		MaxMemory:      s.maxMemory.Load(),
+
+		ChangesSinceSave: s.version.Load() - s.saveVersion.Load(),
+	}
+	if t := s.lastSave.Load(); t != 0 {
+		st.LastSave = time.Unix(t, 0)
+	}
+	now := time.Now()
+	for _, sh := range s.shardList() {
+		sh.mu.RLock()
+		for _, item := range sh.store {
+			if item.expired(now) {
+				continue
+			}
+			st.Keys[item.kind]++
+			if len(item.queue) > 0 && item.queue[0].expiration != nil {
+				st.Expires++
+			}
+			if item.kind == TypeQueue {
+				st.QueueDepth += len(item.queue)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return st
+}
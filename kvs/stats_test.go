@@ -0,0 +1,122 @@
+package kvs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := NewKeyValueStore()
+	s.Set("a", "1", 100, "")
+	s.Set("b", "2", 100, "")
+	s.Qpush("queue", []string{"x", "y", "z"})
+	sh := s.shardFor("expired")
+	sh.mu.Lock()
+	s.setLocked(sh, "expired", "value", time.Millisecond)
+	sh.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	s.Get("expired")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.BqpopContext(ctx, "empty", time.Minute)
+		close(done)
+	}()
+	for i := 0; i < 100 && s.Stats().BlockedClients == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	st := s.Stats()
+	if st.Keys[TypeString] != 2 || st.Keys[TypeQueue] != 1 {
+		t.Errorf("Stats() FAILED: expected 2 strings and 1 queue, but got %v", st.Keys)
+	}
+	if st.QueueDepth != 3 {
+		t.Errorf("Stats() FAILED: expected a queue depth of 3, but got %d", st.QueueDepth)
+	}
+	if st.ExpiredKeys != 1 {
+		t.Errorf("Stats() FAILED: expected 1 expired key, but got %d", st.ExpiredKeys)
+	}
+	if st.BlockedClients != 1 {
+		t.Errorf("Stats() FAILED: expected 1 blocked client, but got %d", st.BlockedClients)
+	}
+	if st.UsedMemory != s.UsedMemory() {
+		t.Errorf("Stats() FAILED: expected %d bytes used, but got %d", s.UsedMemory(), st.UsedMemory)
+	}
+
+	cancel()
+	<-done
+	if n := s.Stats().BlockedClients; n != 0 {
+		t.Errorf("Stats() FAILED: expected no blocked clients once cancelled, but got %d", n)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	s := NewKeyValueStore()
+	s.Set("a:1", "x", 0, "")
+	s.Set("b:1", "x", 0, "")
+	s.SetTenants([]string{"a:", "a:sub:"})
+
+	s.Set("a:2", "x", 0, "")
+	s.Set("a:sub:1", "x", 0, "")
+	s.Qpush("a:q", []string{"1", "2"})
+	s.Set("a:1", "longer value", 0, "") // replaces, still one key
+
+	usage := s.Tenants()
+	if len(usage) != 2 || usage[0].Prefix != "a:" || usage[0].Keys != 3 || usage[1].Keys != 1 {
+		t.Fatalf("Tenants() FAILED: expected 3 keys for a: and 1 for a:sub:, but got %+v", usage)
+	}
+	if usage[0].Memory+usage[1].Memory >= s.UsedMemory() {
+		t.Errorf("Tenants() FAILED: expected less memory than the whole store, but got %+v of %d", usage, s.UsedMemory())
+	}
+
+	s.Del([]string{"a:1", "a:2", "a:q"})
+	if u, ok := s.TenantOf("a:3"); !ok || u.Keys != 0 || u.Memory != 0 {
+		t.Errorf("TenantOf() FAILED: expected an empty tenant, but got %+v, %v", u, ok)
+	}
+	if _, ok := s.TenantOf("b:1"); ok {
+		t.Errorf("TenantOf() FAILED: expected no tenant for b:1")
+	}
+}
+
+func TestTenantQuotas(t *testing.T) {
+	db, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	s := db.Store()
+
+	// Room for exactly one entry, as the memory accounting counts it.
+	s.SetTenantQuotas([]TenantQuota{{Prefix: "k:", Keys: 2}, {Prefix: "m:", Memory: s.entrySize("m:1", "x")}})
+
+	if err := db.Set(ctx, "m:1", "x"); err != nil {
+		t.Fatalf("Set() FAILED: %v", err)
+	}
+	if u, _ := s.TenantOf("m:1"); u.Memory != s.entrySize("m:1", "x") {
+		t.Errorf("TenantOf() FAILED: expected %d bytes, as checked, but got %d", s.entrySize("m:1", "x"), u.Memory)
+	}
+	var quotaErr *QuotaError
+	if err := db.Set(ctx, "m:2", "x"); !errors.As(err, &quotaErr) || quotaErr.Resource != "memory" {
+		t.Errorf("Set() FAILED: expected the memory quota error, but got %v", err)
+	}
+
+	s.Mset([]string{"k:1", "k:2"}, []string{"1", "2"}, 0)
+	if err := s.Qpush("k:3", []string{"a"}); !errors.As(err, &quotaErr) || quotaErr.Resource != "keys" {
+		t.Errorf("Qpush() FAILED: expected the keys quota error, but got %v", err)
+	}
+	if err := db.Set(ctx, "k:1", "replaced"); err != nil {
+		t.Errorf("Set() FAILED: replacing a key must not count as a new one, but got %v", err)
+	}
+	if err := db.Set(ctx, "other", "x"); err != nil {
+		t.Errorf("Set() FAILED: keys of no tenant must not be limited, but got %v", err)
+	}
+
+	want := map[QuotaError]uint64{{"m:", "memory"}: 1, {"k:", "keys"}: 1}
+	if got := s.QuotaRejections(); len(got) != len(want) || got[QuotaError{"m:", "memory"}] != 1 || got[QuotaError{"k:", "keys"}] != 1 {
+		t.Errorf("QuotaRejections() FAILED: expected %v, but got %v", want, got)
+	}
+}
@@ -0,0 +1,175 @@
+package kvs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// tenant counts the keys starting with prefix and the memory they hold.
+type tenant struct {
+	prefix string
+	quota  TenantQuota
+	keys   atomic.Int64
+	memory atomic.Int64
+}
+
+// TenantQuota caps the keys starting with Prefix and the memory they hold.
+// Zero means no limit.
+type TenantQuota struct {
+	Prefix string
+	Keys   int64
+	Memory int64 // approximate bytes, as UsedMemory
+}
+
+// QuotaError is returned for writes that would take a tenant over its quota.
+type QuotaError struct {
+	Tenant   string // the prefix of the tenant
+	Resource string // "keys" or "memory"
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %s is over its %s quota", e.Tenant, e.Resource)
+}
+
+// TenantUsage is what the keys of a tenant hold, see SetTenants.
+type TenantUsage struct {
+	Prefix string
+	Keys   int64
+	Memory int64 // approximate bytes, as UsedMemory
+}
+
+// SetTenants starts counting the keys and memory of each of the key
+// prefixes, without limiting them, see SetTenantQuotas.
+func (s *KeyValueStore) SetTenants(prefixes []string) {
+	quotas := make([]TenantQuota, len(prefixes))
+	for i, prefix := range prefixes {
+		quotas[i].Prefix = prefix
+	}
+	s.SetTenantQuotas(quotas)
+}
+
+// SetTenantQuotas starts counting the keys and memory of the keys starting
+// with each prefix, and refuses writes that would take them over their quota
+// with a *QuotaError. A key counts towards the longest prefix it starts
+// with. The keys already stored are counted right away.
+//
+// Writes are checked under the locks of the shards they write to, so only
+// concurrent writes to other shards of the same tenant can take it slightly
+// over its quota.
+func (s *KeyValueStore) SetTenantQuotas(quotas []TenantQuota) {
+	tenants := make([]*tenant, 0, len(quotas))
+	for _, quota := range quotas {
+		tenants = append(tenants, &tenant{prefix: quota.Prefix, quota: quota})
+	}
+	// Longest first, so that tenantFor returns the first match.
+	sort.Slice(tenants, func(i, j int) bool { return len(tenants[i].prefix) > len(tenants[j].prefix) })
+	s.tenants.Store(&tenants)
+
+	// Keys inserted from here on already count towards the new tenants.
+	for _, sh := range s.shardList() {
+		sh.mu.Lock()
+		for key, item := range sh.store {
+			t := s.tenantFor(key)
+			if item.tenant == t {
+				continue
+			}
+			item.tenant = t
+			if t != nil {
+				t.keys.Add(1)
+				t.memory.Add(item.size)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Tenants returns the usage of every tenant, ordered by prefix.
+func (s *KeyValueStore) Tenants() []TenantUsage {
+	var usage []TenantUsage
+	if tenants := s.tenants.Load(); tenants != nil {
+		for _, t := range *tenants {
+			usage = append(usage, TenantUsage{t.prefix, t.keys.Load(), t.memory.Load()})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Prefix < usage[j].Prefix })
+	return usage
+}
+
+// TenantOf returns the usage of the tenant key belongs to, if any.
+func (s *KeyValueStore) TenantOf(key string) (TenantUsage, bool) {
+	t := s.tenantFor(key)
+	if t == nil {
+		return TenantUsage{}, false
+	}
+	return TenantUsage{t.prefix, t.keys.Load(), t.memory.Load()}, true
+}
+
+func (s *KeyValueStore) tenantFor(key string) *tenant {
+	tenants := s.tenants.Load()
+	if tenants == nil {
+		return nil
+	}
+	for _, t := range *tenants {
+		if strings.HasPrefix(key, t.prefix) {
+			return t
+		}
+	}
+	return nil
+}
+
+// checkQuotas refuses writes that would take a tenant over its quota,
+// estimating them as the memory accounting does. The caller must hold the
+// write locks of the shards of the keys written.
+func (s *KeyValueStore) checkQuotas(writes []write) error {
+	var added, growth map[*tenant]int64 // new keys and bytes, by tenant
+	for _, w := range writes {
+		t := s.tenantFor(w.key)
+		if t == nil || (t.quota.Keys == 0 && t.quota.Memory == 0) {
+			continue
+		}
+		if growth == nil {
+			added, growth = make(map[*tenant]int64), make(map[*tenant]int64)
+		}
+		growth[t] += w.size
+		if t.quota.Memory > 0 && t.memory.Load()+growth[t] > t.quota.Memory {
+			return s.quotaExceeded(t, "memory")
+		}
+		if _, exists := s.shardFor(w.key).store[w.key]; t.quota.Keys > 0 && !exists {
+			added[t]++
+			if t.keys.Load()+added[t] > t.quota.Keys {
+				return s.quotaExceeded(t, "keys")
+			}
+		}
+	}
+	return nil
+}
+
+func (s *KeyValueStore) quotaExceeded(t *tenant, resource string) error {
+	err := QuotaError{t.prefix, resource}
+	n, _ := s.quotaRejected.LoadOrStore(err, new(atomic.Uint64))
+	n.(*atomic.Uint64).Add(1)
+	return &err
+}
+
+// QuotaRejections returns how many writes each quota refused, by tenant and
+// resource.
+func (s *KeyValueStore) QuotaRejections() map[QuotaError]uint64 {
+	rejections := make(map[QuotaError]uint64)
+	s.quotaRejected.Range(func(k, v any) bool {
+		rejections[k.(QuotaError)] = v.(*atomic.Uint64).Load()
+		return true
+	})
+	return rejections
+}
+
+// insert stores a new entry for key, counting it towards its tenant. The
+// caller must hold sh.mu for writing and account for its size with resize.
+func (s *KeyValueStore) insert(sh *shard, key string, item *QueueChannel) {
+	item.tenant = s.tenantFor(key)
+	if item.tenant != nil {
+		item.tenant.keys.Add(1)
+	}
+	sh.store[key] = item
+}
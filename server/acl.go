@@ -0,0 +1,529 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/gin-gonic/gin"
+)
+
+// categories group the commands a user may be allowed to run, as in Redis'
+// ACLs. SUBSCRIBE, MONITOR, METRICS and CHANGES stand for the routes of the
+// same name.
+var categories = map[string][]string{
+	"read":  {"GET", "MGET", "SCAN", "KEYS", "WATCH", "SUBSCRIBE"},
+	"write": {"SET", "MSET", "MSETNX", "DEL", "PUBLISH"},
+	"queue": {"QPUSH", "QPOP", "BQPOP"},
+	"admin": {"CONFIG", "SHUTDOWN", "SLOWLOG", "INFO", "ACL", "MONITOR", "METRICS", "CHANGES"},
+}
+
+// commandCategory is the category of each command in categories.
+var commandCategory = func() map[string]string {
+	m := make(map[string]string)
+	for category, commands := range categories {
+		for _, cmd := range commands {
+			m[cmd] = category
+		}
+	}
+	return m
+}()
+
+// alwaysAllowed are the commands outside of every category, which any user
+// may run: AUTH, and the transaction commands other than WATCH, which only
+// act on commands that are checked themselves.
+var alwaysAllowed = map[string]bool{"AUTH": true, "MULTI": true, "EXEC": true, "DISCARD": true, "UNWATCH": true}
+
+// fullAccess are the rules of the users of an auth file that gives none.
+const fullAccess = "on allcommands allkeys allchannels"
+
+// user is an account clients may authenticate as, along with what it may
+// do once they have.
+type user struct {
+	name      string
+	enabled   bool
+	passwords []string // hashes, as in authFile
+	tokens    []string // hex SHA-256 of each token, from the auth file
+
+	allowed  map[string]bool // by category
+	commands map[string]bool // exceptions to allowed, by command
+	keys     []string        // glob patterns
+	channels []string        // glob patterns
+}
+
+// newUser returns a user that is off and may do nothing, as ACL SETUSER
+// creates them.
+func newUser(name string) *user {
+	return &user{name: name, allowed: make(map[string]bool), commands: make(map[string]bool)}
+}
+
+func (u *user) clone() *user {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.tokens = append([]string(nil), u.tokens...)
+	c.keys = append([]string(nil), u.keys...)
+	c.channels = append([]string(nil), u.channels...)
+	c.allowed = make(map[string]bool)
+	for k, v := range u.allowed {
+		c.allowed[k] = v
+	}
+	c.commands = make(map[string]bool)
+	for k, v := range u.commands {
+		c.commands[k] = v
+	}
+	return &c
+}
+
+// setRules applies the space-separated rules in order, see apply.
+func (u *user) setRules(rules string) error {
+	for _, rule := range strings.Fields(rules) {
+		if err := u.apply(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply changes the user as one rule of ACL SETUSER says:
+//
+//	on, off                 enable or disable the user
+//	>password, <password    add or remove a password
+//	#hash, !hash            add or remove a password by its hash
+//	resetpass               remove every password
+//	+@category, -@category  allow or deny a category, or @all of them
+//	+command, -command      allow or deny one command
+//	allcommands, nocommands same as +@all and -@all
+//	~pattern, allkeys       allow the keys matching a glob, or every key
+//	&pattern, allchannels   allow the channels matching a glob, or all
+//	resetkeys, resetchannels
+//	reset                   back to a new user, but for the tokens
+func (u *user) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "resetpass":
+		u.passwords = nil
+		return nil
+	case "allcommands", "+@all":
+		u.setCategories(true)
+		return nil
+	case "nocommands", "-@all":
+		u.setCategories(false)
+		return nil
+	case "allkeys":
+		u.keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		u.channels = []string{"*"}
+		return nil
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "reset":
+		tokens := u.tokens // only the auth file changes them
+		*u = *newUser(u.name)
+		u.tokens = tokens
+		return nil
+	}
+
+	invalid := errors.New("invalid ACL rule: " + rule)
+	if len(rule) < 2 {
+		return invalid
+	}
+	arg := rule[1:]
+	switch rule[0] {
+	case '>':
+		u.addPassword("sha256:" + sha256Hex(arg))
+	case '<':
+		u.passwords = removeMatching(u.passwords, func(hash string) bool { return checkHash(hash, arg) })
+	case '#', '!':
+		hash := arg
+		if b, err := hex.DecodeString(arg); err == nil && len(b) == 32 {
+			hash = "sha256:" + strings.ToLower(arg)
+		}
+		if !validHash(hash) {
+			return invalid
+		}
+		if rule[0] == '#' {
+			u.addPassword(hash)
+		} else {
+			u.passwords = removeMatching(u.passwords, func(h string) bool { return h == hash })
+		}
+	case '~':
+		u.keys = appendNew(u.keys, arg)
+	case '&':
+		u.channels = appendNew(u.channels, arg)
+	case '+', '-':
+		allow := rule[0] == '+'
+		if category, ok := strings.CutPrefix(strings.ToLower(arg), "@"); ok {
+			if _, ok := categories[category]; !ok {
+				return errors.New("unknown ACL category: " + category)
+			}
+			u.setCategory(category, allow)
+			return nil
+		}
+		cmd := strings.ToUpper(arg)
+		category, ok := commandCategory[cmd]
+		if !ok {
+			return errors.New("unknown command in ACL rule: " + arg)
+		}
+		if u.allowed[category] == allow {
+			delete(u.commands, cmd)
+		} else {
+			u.commands[cmd] = allow
+		}
+	default:
+		return invalid
+	}
+	return nil
+}
+
+func (u *user) setCategories(allow bool) {
+	for category := range categories {
+		u.setCategory(category, allow)
+	}
+}
+
+// setCategory allows or denies a category, dropping the exceptions for its
+// commands as a later rule overrides an earlier one.
+func (u *user) setCategory(category string, allow bool) {
+	u.allowed[category] = allow
+	for _, cmd := range categories[category] {
+		delete(u.commands, cmd)
+	}
+}
+
+func (u *user) addPassword(hash string) {
+	u.passwords = appendNew(u.passwords, hash)
+}
+
+func appendNew(list []string, item string) []string {
+	for _, existing := range list {
+		if existing == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
+
+func removeMatching(list []string, match func(string) bool) []string {
+	var kept []string
+	for _, item := range list {
+		if !match(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// canRun reports whether the user may run the command. A nil user, which
+// stands for everyone while authentication is off, may do anything.
+func (u *user) canRun(operation string, contents []string) bool {
+	switch {
+	case u == nil:
+		return true
+	case operation == "ACL" && len(contents) > 0 && strings.EqualFold(contents[0], "WHOAMI"):
+		return true
+	}
+	if alwaysAllowed[operation] {
+		return true
+	}
+	category, ok := commandCategory[operation]
+	if !ok {
+		return false // a command no category was given, until it is
+	}
+	if allow, ok := u.commands[operation]; ok {
+		return allow
+	}
+	return u.allowed[category]
+}
+
+// canAccessKey reports whether the user may access the key.
+func (u *user) canAccessKey(key string) bool {
+	return u == nil || matchesAny(u.keys, key)
+}
+
+// canAccessChannel reports whether the user may publish or subscribe to the
+// channel.
+func (u *user) canAccessChannel(channel string) bool {
+	return u == nil || matchesAny(u.channels, channel)
+}
+
+// canSubscribePattern reports whether the user may subscribe to the channel
+// pattern, which must be one of its channel patterns, or they must have all
+// channels: a pattern that merely overlaps would reach other channels.
+func (u *user) canSubscribePattern(pattern string) bool {
+	if u == nil {
+		return true
+	}
+	for _, p := range u.channels {
+		if p == "*" || p == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if kvs.MatchPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandRules renders what the user may run as ACL rules, the categories
+// first and then the exceptions.
+func (u *user) commandRules() string {
+	var rules []string
+	all := true
+	for category := range categories {
+		all = all && u.allowed[category]
+	}
+	if all {
+		rules = append(rules, "+@all")
+	} else {
+		rules = append(rules, "-@all")
+		for _, category := range sortedKeys(categories) {
+			if u.allowed[category] {
+				rules = append(rules, "+@"+category)
+			}
+		}
+	}
+	for _, cmd := range sortedKeys(u.commands) {
+		sign := "-"
+		if u.commands[cmd] {
+			sign = "+"
+		}
+		rules = append(rules, sign+strings.ToLower(cmd))
+	}
+	return strings.Join(rules, " ")
+}
+
+// describe renders the user as a line of ACL LIST, which given to ACL
+// SETUSER would recreate it but for its tokens.
+func (u *user) describe() string {
+	parts := []string{"user", u.name, "off"}
+	if u.enabled {
+		parts[2] = "on"
+	}
+	for _, hash := range u.passwords {
+		parts = append(parts, "#"+strings.TrimPrefix(hash, "sha256:"))
+	}
+	for _, p := range u.keys {
+		parts = append(parts, "~"+p)
+	}
+	for _, p := range u.channels {
+		parts = append(parts, "&"+p)
+	}
+	return strings.Join(append(parts, u.commandRules()), " ")
+}
+
+// commandKeys returns the keys a command accesses.
+func commandKeys(operation string, contents []string) []string {
+	switch operation {
+	case "GET", "SET", "QPUSH", "QPOP", "BQPOP":
+		if len(contents) > 0 {
+			return contents[:1]
+		}
+	case "MGET", "DEL", "WATCH":
+		return contents
+	case "MSET", "MSETNX":
+		var keys []string
+		for i := 0; i < len(contents); i += 2 {
+			keys = append(keys, contents[i])
+		}
+		return keys
+	}
+	return nil
+}
+
+// aclUser returns the user called name, or nil while authentication is off.
+func (s *Server) aclUser(name string) *user {
+	creds := s.creds.Load()
+	if creds == nil {
+		return nil
+	}
+	if u, ok := creds.users[name]; ok {
+		return u
+	}
+	return newUser(name)
+}
+
+// authorize checks that cl may run the command and access its keys and
+// channel, before any handler runs.
+func (s *Server) authorize(cl *client, operation string, contents []string) (Result, bool) {
+	u := s.aclUser(cl.user)
+	if !u.canRun(operation, contents) {
+		return noPermission(u.name, "run "+strings.ToLower(operation)), false
+	}
+	for _, key := range commandKeys(operation, contents) {
+		if !u.canAccessKey(key) {
+			return noPermission(u.name, "access key "+key), false
+		}
+	}
+	if operation == "PUBLISH" && len(contents) > 0 && !u.canAccessChannel(contents[0]) {
+		return noPermission(u.name, "access channel "+contents[0]), false
+	}
+	return Result{}, true
+}
+
+func noPermission(name, what string) Result {
+	return Result{http.StatusForbidden, gin.H{"error": fmt.Sprintf("user %s has no permission to %s", name, what)}}
+}
+
+// filterKeys drops from the reply to KEYS or SCAN the keys cl may not
+// access, so that listing the keyspace does not reveal them.
+func (s *Server) filterKeys(cl *client, operation string, res Result) {
+	if operation != "KEYS" && operation != "SCAN" {
+		return
+	}
+	keys, ok := res.Body["keys"].([]string)
+	u := s.aclUser(cl.user)
+	if !ok || u == nil {
+		return
+	}
+	allowed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if u.canAccessKey(key) {
+			allowed = append(allowed, key)
+		}
+	}
+	res.Body["keys"] = allowed
+}
+
+// allow refuses requests to a route unless they are authenticated as a
+// user who may run cmd, see categories.
+func (s *Server) allow(cmd string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := authUser(c.Request.Context())
+		if !s.authenticated(name) {
+			c.Header("WWW-Authenticate", authChallenge)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errAuthRequired.Error()})
+			return
+		}
+		if u := s.aclUser(name); !u.canRun(cmd, nil) {
+			res := noPermission(name, "run "+strings.ToLower(cmd))
+			c.AbortWithStatusJSON(res.Status, res.Body)
+			return
+		}
+		if res, ok := s.throttle(name, c.Request.RemoteAddr, cmd); !ok {
+			setRetryAfter(c, res)
+			c.AbortWithStatusJSON(res.Status, res.Body)
+			return
+		}
+		c.Next()
+	}
+}
+
+// aclCommand implements ACL SETUSER, GETUSER, LIST and WHOAMI.
+func (s *Server) aclCommand(cl *client, contents []string) Result {
+	if len(contents) == 0 {
+		return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for acl"}}
+	}
+	sub := strings.ToUpper(contents[0])
+	if sub == "WHOAMI" {
+		if len(contents) != 1 {
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for acl whoami"}}
+		}
+		name := cl.user
+		if name == "" {
+			name = "default" // authentication is off
+		}
+		return Result{http.StatusOK, gin.H{"user": name}}
+	}
+	if s.creds.Load() == nil {
+		return Result{http.StatusBadRequest, gin.H{"error": "ACL " + sub + " needs users, see auth-file"}}
+	}
+
+	switch sub {
+	case "SETUSER":
+		if len(contents) < 2 {
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for acl setuser"}}
+		}
+		if err := s.setUser(contents[1], contents[2:]); err != nil {
+			return Result{http.StatusBadRequest, gin.H{"error": err.Error()}}
+		}
+		return Result{http.StatusOK, gin.H{"message": "OK"}}
+
+	case "GETUSER":
+		if len(contents) != 2 {
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for acl getuser"}}
+		}
+		u, ok := s.creds.Load().users[contents[1]]
+		if !ok {
+			return Result{http.StatusNotFound, gin.H{"error": "user not found"}}
+		}
+		flags := []string{"off"}
+		if u.enabled {
+			flags[0] = "on"
+		}
+		return Result{http.StatusOK, gin.H{"acl": gin.H{
+			"flags":     flags,
+			"passwords": append([]string{}, u.passwords...),
+			"tokens":    len(u.tokens),
+			"commands":  u.commandRules(),
+			"keys":      append([]string{}, u.keys...),
+			"channels":  append([]string{}, u.channels...),
+		}}}
+
+	case "LIST":
+		if len(contents) != 1 {
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for acl list"}}
+		}
+		users := s.creds.Load().users
+		lines := make([]string, 0, len(users))
+		for _, name := range sortedKeys(users) {
+			lines = append(lines, users[name].describe())
+		}
+		return Result{http.StatusOK, gin.H{"users": lines}}
+	}
+
+	return Result{http.StatusBadRequest, gin.H{"error": "invalid command"}}
+}
+
+// setUser creates or changes the user called name as ACL SETUSER does. The
+// change lasts until the auth file is reloaded.
+func (s *Server) setUser(name string, rules []string) error {
+	if !validUserName(name) {
+		return errors.New("invalid user name: " + name)
+	}
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+
+	creds := s.creds.Load()
+	if creds == nil {
+		return errors.New("authentication is off")
+	}
+	u := newUser(name)
+	if existing, ok := creds.users[name]; ok {
+		u = existing.clone()
+	}
+	if err := u.setRules(strings.Join(rules, " ")); err != nil {
+		return err
+	}
+	creds = creds.with(u)
+	s.creds.Store(creds)
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
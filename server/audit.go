@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/SinisterSup/kv-datastore/kvs"
+)
+
+// DefaultAuditRedact redacts the values of every key.
+const DefaultAuditRedact = "*"
+
+// redacted replaces values in the audit log.
+const redacted = "[REDACTED]"
+
+// SetAuditLog records every command that changes the store or the server to
+// w, as JSON lines, or stops recording if w is nil.
+func (s *Server) SetAuditLog(w io.Writer) {
+	if w == nil {
+		s.audit.Store(nil)
+		return
+	}
+	s.audit.Store(slog.New(requestIDHandler{slog.NewJSONHandler(w, nil)}))
+}
+
+// SetAuditRedact sets which values the audit log redacts: those of keys, or
+// channels for PUBLISH, matching one of a comma-separated list of glob
+// patterns. "*" redacts every value and "" none.
+func (s *Server) SetAuditRedact(rules string) {
+	var patterns []string
+	for _, p := range strings.Split(rules, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	s.redact.Store(&patterns)
+}
+
+// AuditRedact returns the redaction rules, see SetAuditRedact.
+func (s *Server) AuditRedact() string {
+	return strings.Join(*s.redact.Load(), ",")
+}
+
+func (s *Server) redacts(key string) bool {
+	for _, p := range *s.redact.Load() {
+		if kvs.MatchPattern(p, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// mutates reports whether a command changes the store or the server.
+func mutates(operation string, contents []string) bool {
+	switch operation {
+	case "SET", "QPUSH", "MSET", "MSETNX", "DEL", "QPOP", "BQPOP", "PUBLISH", "SHUTDOWN", "AUTH":
+		return true
+	case "CONFIG", "SLOWLOG":
+		return len(contents) > 0 && !strings.EqualFold(contents[0], "GET") && !strings.EqualFold(contents[0], "LEN")
+	case "ACL":
+		return len(contents) > 0 && strings.EqualFold(contents[0], "SETUSER")
+	}
+	return false
+}
+
+// auditArgs returns the keys a command touches and its arguments with the
+// values of those keys redacted as the rules say.
+func (s *Server) auditArgs(operation string, contents []string) (keys, args []string) {
+	args = append([]string(nil), hideSecrets(operation, contents)...)
+	value := func(i int, key string) {
+		if i < len(args) && s.redacts(key) {
+			args[i] = redacted
+		}
+	}
+
+	switch operation {
+	case "SET":
+		if len(args) > 0 {
+			keys = args[:1]
+			value(1, args[0])
+		}
+	case "QPUSH", "PUBLISH":
+		if len(args) > 0 {
+			keys = args[:1]
+			for i := 1; i < len(args); i++ {
+				value(i, args[0])
+			}
+		}
+	case "MSET", "MSETNX":
+		for i := 0; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+			value(i+1, args[i])
+		}
+	case "DEL":
+		keys = args
+	case "QPOP", "BQPOP":
+		if len(args) > 0 {
+			keys = args[:1]
+		}
+	}
+	return append([]string(nil), keys...), args
+}
+
+// auditCommand records a command that changed something, if the audit log
+// is enabled.
+func (s *Server) auditCommand(ctx context.Context, cl *client, operation string, contents []string, res Result) {
+	logger := s.audit.Load()
+	if logger == nil || !mutates(operation, contents) {
+		return
+	}
+	keys, args := s.auditArgs(operation, contents)
+	attrs := []slog.Attr{
+		slog.String("client", cl.addr),
+		slog.String("command", operation),
+		slog.Any("keys", keys),
+		slog.Any("args", args),
+		slog.Int("status", res.Status),
+	}
+	if cl.user != "" {
+		attrs = append(attrs, slog.String("user", cl.user))
+	}
+	if cl.session != nil {
+		attrs = append(attrs, slog.String("session", cl.session.id))
+	}
+	logger.LogAttrs(ctx, slog.LevelInfo, "command", attrs...)
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// authChallenge is sent with 401 responses.
+const authChallenge = `Bearer realm="kvs", Basic realm="kvs"`
+
+var (
+	errAuthRequired = errors.New("authentication required")
+	errWrongPass    = errors.New("invalid username, password or token")
+)
+
+// authFile is the YAML file credentials are loaded from. Secrets are stored
+// hashed, as "sha256:<hex>" or as a bcrypt hash ("$2a$...", "$2b$...").
+// Tokens must be SHA-256 hashes so that they can be looked up on every
+// request; passwords should be bcrypt hashes. Rules are ACL SETUSER rules,
+// applied to a user that is on; a user without any may do anything.
+//
+//	users:
+//	  - name: alice
+//	    passwords: ["$2a$10$..."]
+//	    tokens: ["sha256:5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"]
+//	    rules: "+@read +@queue ~jobs:* &events"
+type authFile struct {
+	Users []struct {
+		Name      string   `yaml:"name"`
+		Passwords []string `yaml:"passwords"`
+		Tokens    []string `yaml:"tokens"`
+		Rules     string   `yaml:"rules"`
+	} `yaml:"users"`
+}
+
+// credentials are the users of the auth file, as changed by ACL SETUSER
+// since it was loaded. They are replaced rather than changed, so that
+// requests can use them without locking.
+type credentials struct {
+	users  map[string]*user
+	tokens map[string]string // user names by the hex SHA-256 of the token
+
+	// verified caches the passwords that matched a bcrypt hash, by user name
+	// and SHA-256 of the password, as bcrypt is too slow to run for every
+	// request using basic auth.
+	verified sync.Map
+}
+
+// loadCredentials reads and checks the auth file at path.
+func loadCredentials(path string) (*credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file authFile
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	creds := &credentials{users: make(map[string]*user), tokens: make(map[string]string)}
+	for _, u := range file.Users {
+		if !validUserName(u.Name) {
+			return nil, fmt.Errorf("%s: invalid user name %q", path, u.Name)
+		}
+		if _, ok := creds.users[u.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate user %q", path, u.Name)
+		}
+		usr := newUser(u.Name)
+		rules := fullAccess
+		if u.Rules != "" {
+			rules = "on " + u.Rules
+		}
+		if err := usr.setRules(rules); err != nil {
+			return nil, fmt.Errorf("%s: user %q: %w", path, u.Name, err)
+		}
+		for _, hash := range u.Passwords {
+			if !validHash(hash) {
+				return nil, fmt.Errorf("%s: user %q: invalid password hash", path, u.Name)
+			}
+			usr.passwords = append(usr.passwords, hash)
+		}
+		for _, hash := range u.Tokens {
+			digest, ok := strings.CutPrefix(hash, "sha256:")
+			if !ok || !validHash(hash) {
+				return nil, fmt.Errorf("%s: user %q: tokens must be sha256 hashes", path, u.Name)
+			}
+			digest = strings.ToLower(digest)
+			if _, ok := creds.tokens[digest]; ok {
+				return nil, fmt.Errorf("%s: user %q: duplicate token", path, u.Name)
+			}
+			creds.tokens[digest] = u.Name
+			usr.tokens = append(usr.tokens, digest)
+		}
+		creds.users[u.Name] = usr
+	}
+	return creds, nil
+}
+
+// validUserName reports whether name can be used in commands and basic auth.
+func validUserName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\r\n:")
+}
+
+// with returns a copy of creds in which u replaces the user of the same name.
+func (creds *credentials) with(u *user) *credentials {
+	c := &credentials{users: make(map[string]*user, len(creds.users)+1), tokens: creds.tokens}
+	for name, existing := range creds.users {
+		c.users[name] = existing
+	}
+	c.users[u.name] = u
+	return c
+}
+
+// validHash reports whether hash is in one of the formats of authFile.
+func validHash(hash string) bool {
+	if digest, ok := strings.CutPrefix(hash, "sha256:"); ok {
+		b, err := hex.DecodeString(digest)
+		return err == nil && len(b) == sha256.Size
+	}
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+func sha256Hex(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkHash reports whether password matches hash.
+func checkHash(hash, password string) bool {
+	if want, ok := strings.CutPrefix(hash, "sha256:"); ok {
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(want)), []byte(sha256Hex(password))) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// token returns the enabled user a bearer token belongs to.
+func (creds *credentials) token(token string) (*user, bool) {
+	u, ok := creds.users[creds.tokens[sha256Hex(token)]]
+	if !ok || !u.enabled {
+		return nil, false
+	}
+	return u, true
+}
+
+// password returns the user called name if they are enabled and password is
+// one of theirs.
+func (creds *credentials) password(name, password string) (*user, bool) {
+	u, ok := creds.users[name]
+	if !ok || !u.enabled {
+		return nil, false
+	}
+	cacheKey := name + "\x00" + sha256Hex(password)
+	if _, ok := creds.verified.Load(cacheKey); ok {
+		return u, true
+	}
+	for _, hash := range u.passwords {
+		if checkHash(hash, password) {
+			creds.verified.Store(cacheKey, true)
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// SetAuthFile requires clients to authenticate as one of the users of the
+// auth file at path, see authFile, or lets anyone in if path is empty. The
+// file is read right away; while it is invalid the credentials in effect are
+// kept.
+func (s *Server) SetAuthFile(path string) error {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+
+	if path == "" {
+		s.creds.Store(nil)
+		s.authPath.Store("")
+		return nil
+	}
+	creds, err := loadCredentials(path)
+	if err != nil {
+		return err
+	}
+	s.creds.Store(creds)
+	s.authPath.Store(path)
+	s.Logger().Info("credentials loaded", "path", path, "users", len(creds.users))
+	return nil
+}
+
+// AuthFile returns the path of the auth file, see SetAuthFile.
+func (s *Server) AuthFile() string {
+	return s.authPath.Load().(string)
+}
+
+// ReloadAuth reads the auth file again, to rotate passwords and tokens
+// without a restart. Requests already authenticated are not affected, and
+// sessions stay authenticated as long as their user still exists. Changes
+// made with ACL SETUSER are lost.
+func (s *Server) ReloadAuth() error {
+	return s.SetAuthFile(s.AuthFile())
+}
+
+type userKey struct{}
+
+// authUser returns the user the request ctx belongs to authenticated as, or
+// "".
+func authUser(ctx context.Context) string {
+	name, _ := ctx.Value(userKey{}).(string)
+	return name
+}
+
+// authenticated reports whether a client authenticated as name may run
+// commands.
+func (s *Server) authenticated(name string) bool {
+	creds := s.creds.Load()
+	if creds == nil {
+		return true
+	}
+	u, ok := creds.users[name]
+	return ok && u.enabled
+}
+
+// authenticate finds out who sent the request, from its Authorization header,
+// or else the user its session authenticated as with AUTH, or else its
+// client certificate, see SetTLS. A request
+// with invalid credentials is refused; one without is let through, for the
+// routes that require them to refuse it or for it to run AUTH.
+func (s *Server) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		creds := s.creds.Load()
+		if creds == nil {
+			c.Next()
+			return
+		}
+
+		var name string
+		if header := c.GetHeader("Authorization"); header != "" {
+			var u *user
+			ok := false
+			if token, isBearer := cutScheme(header, "Bearer"); isBearer {
+				u, ok = creds.token(token)
+			} else if username, password, isBasic := c.Request.BasicAuth(); isBasic {
+				u, ok = creds.password(username, password)
+			}
+			if !ok {
+				s.Logger().WarnContext(c.Request.Context(), "authentication failed", "client", c.Request.RemoteAddr)
+				c.Header("WWW-Authenticate", authChallenge)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errWrongPass.Error()})
+				return
+			}
+			name = u.name
+		} else if id := c.GetHeader(SessionHeader); id != "" {
+			if sess, ok := s.sessions.get(id); ok {
+				name, _ = sess.user.Load().(string)
+			}
+		}
+		if name == "" {
+			name = certUser(c.Request)
+		}
+
+		if name != "" {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), userKey{}, name))
+		}
+		c.Next()
+	}
+}
+
+// cutScheme returns the credentials of an Authorization header using the
+// given scheme, which is case-insensitive.
+func cutScheme(header, scheme string) (string, bool) {
+	if len(header) <= len(scheme) || header[len(scheme)] != ' ' || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(scheme):]), true
+}
+
+// authCommand implements AUTH token and AUTH username password. The session
+// it starts, or that of cl, is authenticated for the requests that send it.
+func (s *Server) authCommand(cl *client, contents []string) Result {
+	creds := s.creds.Load()
+	if creds == nil {
+		return Result{http.StatusBadRequest, gin.H{"error": "AUTH called without any credentials configured"}}
+	}
+
+	var u *user
+	var ok bool
+	switch len(contents) {
+	case 1:
+		u, ok = creds.token(contents[0])
+	case 2:
+		u, ok = creds.password(contents[0], contents[1])
+	default:
+		return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for auth"}}
+	}
+	if !ok {
+		s.Logger().WarnContext(cl.ctx, "authentication failed", "client", cl.addr)
+		return Result{http.StatusUnauthorized, gin.H{"error": errWrongPass.Error()}}
+	}
+
+	if cl.session == nil {
+		cl.session = s.sessions.create()
+	}
+	cl.session.user.Store(u.name)
+	cl.user = u.name
+	return Result{http.StatusOK, gin.H{"message": "OK", "session": cl.session.id}}
+}
+
+// hideSecrets returns the arguments of a command as they may be shown in the
+// slow log, to monitors and in the audit log: those of AUTH are credentials,
+// and so are the password rules of ACL SETUSER.
+func hideSecrets(operation string, contents []string) []string {
+	if operation != "AUTH" && operation != "ACL" {
+		return contents
+	}
+	hidden := append([]string(nil), contents...)
+	for i, arg := range hidden {
+		if operation == "AUTH" || strings.HasPrefix(arg, ">") || strings.HasPrefix(arg, "<") {
+			hidden[i] = redacted
+		}
+	}
+	return hidden
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits for GET /changes.
+const (
+	defaultChangesLimit = 100
+	maxChangesWait      = 60 * time.Second
+)
+
+// handleChanges serves the change-data-capture feed:
+//
+//	GET /changes?offset=<seq>&limit=<n>&wait=<duration>
+//
+// It answers with the changes starting at offset (0 for the oldest retained)
+// and the offset to ask for next. When there is nothing new, which includes
+// offset 0 while nothing is retained, it waits up to wait (e.g. "30s") for a
+// change before answering with an empty list, so clients can long-poll by
+// always sending back the returned "next". An offset that has fallen out of
+// the retention window answers 410 Gone.
+func (s *Server) handleChanges(c *gin.Context) {
+	log := s.store.ChangeLog()
+	if log == nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "change feed is disabled"})
+		return
+	}
+
+	offset, err := strconv.ParseUint(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultChangesLimit)))
+	if err != nil || limit < 1 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	wait, err := time.ParseDuration(c.DefaultQuery("wait", "0s"))
+	if err != nil || wait < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid wait"})
+		return
+	}
+	if wait > maxChangesWait {
+		wait = maxChangesWait
+	}
+
+	if wait > 0 {
+		from := offset
+		if from == 0 {
+			from, _ = log.Bounds()
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+		log.Wait(ctx, from)
+		cancel()
+	}
+
+	changes, next, err := log.Read(offset, limit)
+	oldest, _ := log.Bounds()
+	if errors.Is(err, kvs.ErrOffsetTruncated) {
+		c.IndentedJSON(http.StatusGone, gin.H{"error": err.Error(), "oldest": oldest})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"changes": changes, "next": next, "oldest": oldest})
+}
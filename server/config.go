@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/gin-gonic/gin"
+)
+
+// param is a setting exposed through CONFIG GET and CONFIG SET.
+type param struct {
+	get func() string
+	set func(string) error // nil for settings that need a restart
+}
+
+// RegisterConfig exposes a setting through CONFIG GET, and through CONFIG
+// SET unless set is nil. It must be called before the server is started.
+func (s *Server) RegisterConfig(name string, get func() string, set func(string) error) {
+	s.params[name] = param{get: get, set: set}
+}
+
+// SetConfig changes a setting as CONFIG SET does.
+func (s *Server) SetConfig(name, value string) error {
+	p, ok := s.params[name]
+	if !ok {
+		return errors.New("unknown config parameter: " + name)
+	}
+	if p.set == nil {
+		return errors.New("config parameter " + name + " can not be changed at runtime")
+	}
+	return p.set(value)
+}
+
+// registerDefaultConfig exposes the settings of the store and the server.
+func (s *Server) registerDefaultConfig() {
+	s.RegisterConfig("maxmemory",
+		func() string {
+			limit, _ := s.store.MaxMemory()
+			return strconv.FormatInt(limit, 10)
+		},
+		func(v string) error {
+			limit, err := kvs.ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			_, policy := s.store.MaxMemory()
+			return s.store.SetMaxMemory(limit, policy)
+		})
+	s.RegisterConfig("maxmemory-policy",
+		func() string {
+			_, policy := s.store.MaxMemory()
+			return policy
+		},
+		func(v string) error {
+			limit, _ := s.store.MaxMemory()
+			return s.store.SetMaxMemory(limit, v)
+		})
+	s.RegisterConfig("notify-keyspace-events", s.store.Notifications, s.SetNotifications)
+	s.RegisterConfig("default-ttl",
+		func() string { return s.store.DefaultTTL().String() },
+		func(v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			return s.store.SetDefaultTTL(d)
+		})
+	s.RegisterConfig("queue-ttl",
+		func() string { return s.store.QueueTTL().String() },
+		func(v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			return s.store.SetQueueTTL(d)
+		})
+	s.RegisterConfig("queue-buffer",
+		func() string { return strconv.Itoa(s.store.QueueBuffer()) },
+		func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			return s.store.SetQueueBuffer(n)
+		})
+	s.RegisterConfig("subscriber-buffer",
+		func() string { return strconv.FormatInt(s.subscriberBuffer.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return errors.New("invalid subscriber buffer size")
+			}
+			s.subscriberBuffer.Store(int64(n))
+			return nil
+		})
+	s.RegisterConfig("loglevel", s.LogLevel, s.SetLogLevel)
+	s.RegisterConfig("audit-redact", s.AuditRedact,
+		func(v string) error {
+			s.SetAuditRedact(v)
+			return nil
+		})
+	s.RegisterConfig("slowlog-threshold",
+		func() string { return time.Duration(s.slowlog.threshold.Load()).String() },
+		func(v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			s.slowlog.threshold.Store(int64(d))
+			return nil
+		})
+	s.RegisterConfig("slowlog-max-len",
+		func() string { return strconv.Itoa(s.slowlog.getMaxLen()) },
+		func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			return s.slowlog.setMaxLen(n)
+		})
+	// Changing the auth file could turn authentication off, and the snapshot
+	// path could make SHUTDOWN SAVE write anywhere, so both are only set at
+	// startup, with SetAuthFile and SetSnapshotPath.
+	s.RegisterConfig("auth-file", s.AuthFile, nil)
+	s.RegisterConfig("snapshot", s.SnapshotPath, nil)
+	s.RegisterConfig("shutdown-timeout",
+		func() string { return s.ShutdownTimeout().String() },
+		func(v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return errors.New("invalid shutdown timeout: " + v)
+			}
+			s.shutdownTimeout.Store(int64(d))
+			return nil
+		})
+	s.registerRateLimitConfig()
+	s.RegisterConfig("tenant-quotas", s.TenantQuotas, s.SetTenantQuotas)
+}
+
+// configCommand implements CONFIG GET <pattern> and CONFIG SET <name> <value>.
+func (s *Server) configCommand(contents []string) Result {
+	if len(contents) == 0 {
+		return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for config"}}
+	}
+
+	switch strings.ToUpper(contents[0]) {
+	case "GET":
+		if len(contents) != 2 {
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for config get"}}
+		}
+		names := make([]string, 0, len(s.params))
+		for name := range s.params {
+			if kvs.MatchPattern(contents[1], name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		config := make(gin.H, len(names))
+		for _, name := range names {
+			config[name] = s.params[name].get()
+		}
+		return Result{http.StatusOK, gin.H{"config": config}}
+
+	case "SET":
+		if len(contents) != 3 {
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for config set"}}
+		}
+		name, value := contents[1], contents[2]
+		if value == `""` {
+			value = "" // the only way to send an empty value
+		}
+		if err := s.SetConfig(name, value); err != nil {
+			return Result{http.StatusBadRequest, gin.H{"error": err.Error()}}
+		}
+		return Result{http.StatusOK, gin.H{"message": "OK"}}
+	}
+
+	return Result{http.StatusBadRequest, gin.H{"error": "invalid command"}}
+}
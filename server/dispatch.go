@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/handle"
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/gin-gonic/gin"
+)
+
+// Result is the outcome of a single command: the HTTP status it maps to and
+// the JSON body sent back to the client.
+type Result struct {
+	Status int
+	Body   gin.H
+}
+
+// writeCommands are served by POST /, readCommands by GET /. A pipeline may
+// mix both. CONFIG, SLOWLOG and ACL are served by both, as they both read
+// and change state, but GET / only serves their readSubcommands.
+var (
+	writeCommands = map[string]bool{
+		"SET": true, "QPUSH": true, "MSET": true, "MSETNX": true, "DEL": true, "PUBLISH": true,
+		"MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true, "UNWATCH": true, "CONFIG": true,
+		"SHUTDOWN": true, "SLOWLOG": true, "AUTH": true, "ACL": true,
+	}
+	readCommands = map[string]bool{
+		"GET": true, "QPOP": true, "BQPOP": true, "MGET": true, "SCAN": true, "KEYS": true, "CONFIG": true,
+		"INFO": true, "SLOWLOG": true, "ACL": true,
+	}
+	readSubcommands = map[string]map[string]bool{
+		"CONFIG":  {"GET": true},
+		"SLOWLOG": {"GET": true, "LEN": true},
+		"ACL":     {"WHOAMI": true, "LIST": true, "GETUSER": true},
+	}
+)
+
+// readOnly refuses, with 400 Bad Request, the subcommands of CONFIG, SLOWLOG
+// and ACL that change state, so that a GET never does: caches, prefetchers
+// and cross-site requests may send one.
+func readOnly(cmd string) (Result, bool) {
+	operation, contents := ParseCommand(cmd)
+	subcommands, ok := readSubcommands[operation]
+	if !ok {
+		return Result{}, true
+	}
+	sub := ""
+	if len(contents) > 0 {
+		sub = strings.ToUpper(contents[0])
+	}
+	if subcommands[sub] {
+		return Result{}, true
+	}
+	return Result{http.StatusBadRequest, gin.H{"error": strings.TrimSpace(operation+" "+sub) + " must be sent with POST"}}, false
+}
+
+// client is the state of whoever sent the command.
+type client struct {
+	ctx     context.Context // done once the client goes away or the server shuts down
+	addr    string          // network address, for the slow log
+	session *session        // nil until MULTI, WATCH or AUTH starts one
+	user    string          // who the client authenticated as, if anyone
+}
+
+func ParseCommand(cmd string) (string, []string) {
+	theCommand := strings.Trim(cmd, " ")
+	cmdParts := strings.Split(theCommand, " ")
+	operation := cmdParts[0]
+	contents := cmdParts[1:]
+	return operation, contents
+}
+
+// failure maps a handler error to a Result. Errors reported with done set are
+// server errors; writes over a tenant quota are refused with 507 Insufficient
+// Storage, and the rest use the status given by the caller.
+func failure(err error, done bool, status int) Result {
+	var quotaErr *kvs.QuotaError
+	if errors.As(err, &quotaErr) {
+		return Result{http.StatusInsufficientStorage, gin.H{"error": err.Error()}}
+	}
+	if done {
+		return Result{http.StatusInternalServerError, gin.H{"error": err.Error()}}
+	}
+	return Result{status, gin.H{"error": err.Error()}}
+}
+
+// withStatus returns the body of res with its status folded in, as used for
+// each entry of a pipeline or EXEC response.
+func withStatus(res Result) gin.H {
+	res.Body["status"] = res.Status
+	return res.Body
+}
+
+// execute runs one command for cl, provided its operation is one of allowed.
+// While cl is inside MULTI the command is queued instead.
+func (s *Server) execute(cl *client, cmd string, allowed ...map[string]bool) Result {
+	operation, contents := ParseCommand(cmd)
+	if operation != "AUTH" && !s.authenticated(cl.user) {
+		return Result{http.StatusUnauthorized, gin.H{"error": errAuthRequired.Error()}}
+	}
+
+	permitted := false
+	for _, commands := range allowed {
+		permitted = permitted || commands[operation]
+	}
+	if !permitted {
+		return Result{http.StatusBadRequest, gin.H{"error": "invalid command"}}
+	}
+	if res, ok := s.authorize(cl, operation, contents); !ok {
+		return res
+	}
+	if res, ok := s.throttle(cl.user, cl.addr, operation); !ok {
+		return res
+	}
+	s.monitor(cl, cmd)
+
+	switch operation {
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+		start := time.Now()
+		res := s.transaction(cl, operation, contents)
+		s.observe(cl, operation, contents, res, time.Since(start))
+		return res
+	}
+
+	if cl.session != nil && cl.session.inMulti {
+		if operation == "BQPOP" || operation == "SHUTDOWN" || operation == "AUTH" || operation == "ACL" {
+			return Result{http.StatusBadRequest, gin.H{"error": operation + " is not allowed in a transaction"}}
+		}
+		cl.session.queued = append(cl.session.queued, cmd)
+		return Result{http.StatusAccepted, gin.H{"message": "QUEUED"}}
+	}
+
+	// Blocking pops may wait for a long time, so they must not hold up EXEC.
+	if operation != "BQPOP" {
+		s.txMu.RLock()
+		defer s.txMu.RUnlock()
+	}
+	if operation == "AUTH" || operation == "ACL" {
+		start := time.Now()
+		var res Result
+		if operation == "AUTH" {
+			res = s.authCommand(cl, contents)
+		} else {
+			res = s.aclCommand(cl, contents)
+		}
+		s.observe(cl, operation, contents, res, time.Since(start))
+		s.auditCommand(cl.ctx, cl, operation, contents, res)
+		return res
+	}
+	return s.run(cl, operation, contents)
+}
+
+// transaction implements MULTI, EXEC, DISCARD, WATCH and UNWATCH.
+func (s *Server) transaction(cl *client, operation string, contents []string) Result {
+	switch operation {
+	case "MULTI":
+		if len(contents) != 0 {
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for multi"}}
+		}
+		if cl.session == nil {
+			cl.session = s.sessions.create()
+		}
+		if cl.session.inMulti {
+			return Result{http.StatusBadRequest, gin.H{"error": "MULTI calls can not be nested"}}
+		}
+		cl.session.inMulti = true
+		return Result{http.StatusOK, gin.H{"message": "OK", "session": cl.session.id}}
+
+	case "WATCH":
+		if len(contents) < 1 {
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for watch"}}
+		}
+		if cl.session == nil {
+			cl.session = s.sessions.create()
+		}
+		if cl.session.inMulti {
+			return Result{http.StatusBadRequest, gin.H{"error": "WATCH inside MULTI is not allowed"}}
+		}
+		if cl.session.watched == nil {
+			cl.session.watched = make(map[string]uint64)
+		}
+		for _, key := range contents {
+			if _, ok := cl.session.watched[key]; !ok {
+				cl.session.watched[key] = s.store.Version(key)
+			}
+		}
+		return Result{http.StatusOK, gin.H{"message": "OK", "session": cl.session.id}}
+
+	case "UNWATCH":
+		if cl.session != nil {
+			cl.session.watched = nil
+		}
+		return Result{http.StatusOK, gin.H{"message": "OK"}}
+
+	case "DISCARD":
+		if cl.session == nil || !cl.session.inMulti {
+			return Result{http.StatusBadRequest, gin.H{"error": "DISCARD without MULTI"}}
+		}
+		cl.session.reset()
+		return Result{http.StatusOK, gin.H{"message": "OK"}}
+
+	case "EXEC":
+		if cl.session == nil || !cl.session.inMulti {
+			return Result{http.StatusBadRequest, gin.H{"error": "EXEC without MULTI"}}
+		}
+		queued, watched := cl.session.queued, cl.session.watched
+		cl.session.reset()
+
+		s.txMu.Lock()
+		defer s.txMu.Unlock()
+
+		for key, version := range watched {
+			if s.store.Version(key) != version {
+				return Result{http.StatusConflict, gin.H{"error": "transaction aborted, watched key " + strconv.Quote(key) + " was modified", "results": nil}}
+			}
+		}
+
+		results := make([]gin.H, len(queued))
+		for i, cmd := range queued {
+			operation, contents := ParseCommand(cmd)
+			results[i] = withStatus(s.run(cl, operation, contents))
+		}
+		return Result{http.StatusOK, gin.H{"results": results}}
+	}
+
+	return Result{http.StatusBadRequest, gin.H{"error": "invalid command"}}
+}
+
+// run executes a single command against the store for cl and records it in
+// the metrics, the slow log and the audit log. Blocking commands give up once cl.ctx is
+// done.
+func (s *Server) run(cl *client, operation string, contents []string) Result {
+	start := time.Now()
+	res := s.runCommand(cl.ctx, operation, contents)
+	s.filterKeys(cl, operation, res)
+	s.observe(cl, operation, contents, res, time.Since(start))
+	s.auditCommand(cl.ctx, cl, operation, contents, res)
+	return res
+}
+
+// observe records a command that ran for d.
+func (s *Server) observe(cl *client, operation string, contents []string, res Result, d time.Duration) {
+	s.metrics.observe(operation, res.Status, d)
+	if operation != "BQPOP" { // slow by design
+		s.slowlog.record(operation, hideSecrets(operation, contents), cl.addr, d)
+	}
+}
+
+func (s *Server) runCommand(ctx context.Context, operation string, contents []string) Result {
+	switch operation {
+	case "SET":
+		message, version, done, err := handle.SetWithVersionHandler(contents, s.store)
+		if errors.Is(err, handle.ErrVersionMismatch) {
+			return Result{http.StatusPreconditionFailed, gin.H{"error": err.Error(), "version": version}}
+		}
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		if !done {
+			return Result{http.StatusNotModified, gin.H{"message": message}}
+		}
+		return Result{http.StatusOK, gin.H{"message": message, "version": version}}
+
+	case "QPUSH":
+		message, done, err := handle.QpushHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		return Result{http.StatusOK, gin.H{"message": message}}
+
+	case "MSET":
+		message, done, err := handle.MsetHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		return Result{http.StatusOK, gin.H{"message": message}}
+
+	case "MSETNX":
+		message, done, err := handle.MsetnxHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		if !done {
+			return Result{http.StatusNotModified, gin.H{"message": message}}
+		}
+		return Result{http.StatusOK, gin.H{"message": message}}
+
+	case "DEL":
+		deleted, done, err := handle.DelHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		return Result{http.StatusOK, gin.H{"deleted": deleted}}
+
+	case "GET":
+		val, version, done, err := handle.GetWithVersionHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusNotFound)
+		}
+		return Result{http.StatusOK, gin.H{"value": val, "version": version}}
+
+	case "QPOP":
+		val, done, err := handle.QpopHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusNotFound)
+		}
+		return Result{http.StatusOK, gin.H{"value": val}}
+
+	case "BQPOP":
+		val, done, err := handle.BqpopContextHandler(ctx, contents, s.store)
+		if err != nil && s.closing.Load() {
+			return Result{http.StatusServiceUnavailable, gin.H{"error": errShuttingDown.Error()}}
+		}
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		return Result{http.StatusOK, gin.H{"value": val}}
+
+	case "MGET":
+		values, done, err := handle.MgetHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		return Result{http.StatusOK, gin.H{"values": values}}
+
+	case "SCAN":
+		cursor, keys, done, err := handle.ScanHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		return Result{http.StatusOK, gin.H{"cursor": cursor, "keys": keys}}
+
+	case "PUBLISH":
+		receivers, done, err := handle.PublishHandler(contents, s.pubsub)
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		return Result{http.StatusOK, gin.H{"receivers": receivers}}
+
+	case "CONFIG":
+		return s.configCommand(contents)
+
+	case "SHUTDOWN":
+		return s.shutdownCommand(contents)
+
+	case "INFO":
+		return s.infoCommand(contents)
+
+	case "SLOWLOG":
+		return s.slowlogCommand(contents)
+
+	case "KEYS":
+		keys, done, err := handle.KeysHandler(contents, s.store)
+		if err != nil {
+			return failure(err, done, http.StatusBadRequest)
+		}
+		return Result{http.StatusOK, gin.H{"keys": keys}}
+	}
+
+	return Result{http.StatusBadRequest, gin.H{"error": "invalid command"}}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Versions returned by GET and SET are exposed as strong ETags, so plain HTTP
+// conditional requests give lock-free read-modify-write:
+//
+//	GET  + If-None-Match: "<v>"  answers 304 while the key is still at v
+//	SET  + If-Match: "<v>"       becomes SET ... IFVERSION v
+//	SET  + If-Match: *           becomes SET ... XX
+//	SET  + If-None-Match: *      becomes SET ... NX
+//
+// A SET that already has NX or XX can not take either header.
+
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseETag reads a single entity tag as sent in If-Match or If-None-Match.
+// Weak tags are accepted since versions are exact either way.
+func parseETag(header string) (uint64, bool) {
+	tag := strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	return version, err == nil
+}
+
+// conditionalSet rewrites a SET command according to the request's
+// If-Match and If-None-Match headers. Other commands are returned unchanged.
+func conditionalSet(c *gin.Context, cmd string) (string, error) {
+	operation, contents := ParseCommand(cmd)
+	if operation != "SET" {
+		return cmd, nil
+	}
+	match := c.GetHeader("If-Match")
+	noneMatch := strings.TrimSpace(c.GetHeader("If-None-Match"))
+	if match == "" && noneMatch != "*" {
+		return cmd, nil
+	}
+	for i := 2; i < len(contents); i++ {
+		if strings.EqualFold(contents[i], "NX") || strings.EqualFold(contents[i], "XX") {
+			return "", errors.New("If-Match and If-None-Match can not be combined with NX or XX")
+		}
+	}
+
+	cmd = strings.Trim(cmd, " ")
+	if match != "" {
+		if strings.TrimSpace(match) == "*" {
+			return cmd + " XX", nil
+		}
+		version, ok := parseETag(match)
+		if !ok {
+			return "", errors.New("invalid If-Match header")
+		}
+		return cmd + " IFVERSION " + strconv.FormatUint(version, 10), nil
+	}
+	return cmd + " NX", nil
+}
+
+// setETag copies the version of a single-command result into the ETag
+// header. It reports whether the request's If-None-Match already names that
+// version, in which case the caller answers 304 without a body.
+func setETag(c *gin.Context, res Result) bool {
+	version, ok := res.Body["version"].(uint64)
+	if !ok || res.Status != http.StatusOK {
+		return false
+	}
+	c.Header("ETag", formatETag(version))
+
+	if c.Request.Method != http.MethodGet {
+		return false
+	}
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		if v, ok := parseETag(tag); ok && v == version {
+			return true
+		}
+	}
+	return false
+}
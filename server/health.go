@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessCheck is one of the checks behind /readyz. It returns an error
+// while the server should not receive traffic.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// AddReadinessCheck adds a check to /readyz, e.g. for a dependency of the
+// program embedding the server. It must be called before the server is
+// started.
+func (s *Server) AddReadinessCheck(name string, check func() error) {
+	s.checks = append(s.checks, readinessCheck{name, check})
+}
+
+// defaultChecks are the checks every server runs, ahead of the ones added
+// with AddReadinessCheck.
+func (s *Server) defaultChecks() []readinessCheck {
+	return []readinessCheck{
+		{"loading", func() error {
+			if s.loading.Load() {
+				return errors.New("loading the snapshot")
+			}
+			return nil
+		}},
+		{"shutdown", func() error {
+			if s.closing.Load() {
+				return errShuttingDown
+			}
+			return nil
+		}},
+		{"memory", func() error {
+			limit, _ := s.store.MaxMemory()
+			if used := s.store.UsedMemory(); limit > 0 && used > limit {
+				return errors.New("used memory " + humanBytes(used) + " is above maxmemory " + humanBytes(limit))
+			}
+			return nil
+		}},
+	}
+}
+
+// LoadSnapshot starts loading the snapshot at path into the store, see
+// kvs.KeyValueStore.LoadFile, and returns a channel that receives the
+// result. The server counts as loading from before LoadSnapshot returns
+// until the result is sent: /readyz reports it as not ready and commands are
+// refused, so that it may be called before the server is started without
+// any write being overwritten by the snapshot.
+func (s *Server) LoadSnapshot(path string) <-chan error {
+	s.loading.Store(true)
+	done := make(chan error, 1)
+	go func() {
+		defer s.loading.Store(false)
+		done <- s.store.LoadFile(path)
+	}()
+	return done
+}
+
+// refuseWhileLoading answers commands with 503 while a snapshot is loading.
+func (s *Server) refuseWhileLoading() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.loading.Load() {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server is loading the snapshot"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleHealthz reports that the process is alive and serving:
+//
+//	GET /healthz
+func (s *Server) handleHealthz(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, gin.H{"status": "ok", "uptime_seconds": int64(time.Since(s.started).Seconds())})
+}
+
+// handleReadyz runs the readiness checks and answers 200 if all of them
+// pass, 503 otherwise, with the result of each:
+//
+//	GET /readyz
+//	{"status": "not ready", "checks": {"loading": {"status": "fail", "error": "loading the snapshot"}, ...}}
+func (s *Server) handleReadyz(c *gin.Context) {
+	status, ready := http.StatusOK, "ready"
+	results := gin.H{}
+	for _, rc := range append(s.defaultChecks(), s.checks...) {
+		if err := rc.check(); err != nil {
+			status, ready = http.StatusServiceUnavailable, "not ready"
+			results[rc.name] = gin.H{"status": "fail", "error": err.Error()}
+			continue
+		}
+		results[rc.name] = gin.H{"status": "ok"}
+	}
+	c.IndentedJSON(status, gin.H{"status": ready, "checks": results})
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestHealthLoading(t *testing.T) {
+	s := newServer()
+	do(t, s, http.MethodPost, `{"command": "SET key value"}`)
+	probe := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+		}
+		return rec.Code, resp
+	}
+
+	// A FIFO makes LoadSnapshot block until something is written to it.
+	path := filepath.Join(t.TempDir(), "dump.jsonl")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skip("no FIFO support:", err)
+	}
+	loaded := s.LoadSnapshot(path)
+	if status, _ := probe("/readyz"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected: not ready while loading, but Got: %v", status)
+	}
+	if status, _ := do(t, s, http.MethodGet, `{"command": "GET key"}`); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected: commands refused while loading, but Got: %v", status)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"key":"loaded","type":"string","items":[{"value":"yes"}]}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := probe("/readyz"); status != http.StatusOK {
+		t.Errorf("Expected: ready once loaded, but Got: %v", status)
+	}
+	if _, resp := do(t, s, http.MethodGet, `{"command": "GET loaded"}`); resp["value"] != "yes" {
+		t.Errorf("Expected: the loaded key, but Got: %v", resp)
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/gin-gonic/gin"
+)
+
+// infoSections are the sections reported by INFO, in order.
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "keyspace"}
+
+// infoField is one line of an INFO section.
+type infoField struct {
+	name  string
+	value any
+}
+
+// info gathers the fields of the given sections; all of them if none are
+// given, or if given "all".
+func (s *Server) info(sections ...string) (map[string][]infoField, error) {
+	if len(sections) == 0 || len(sections) == 1 && strings.EqualFold(sections[0], "all") {
+		sections = infoSections
+	}
+	st := s.store.Stats()
+	out := make(map[string][]infoField, len(sections))
+	for _, section := range sections {
+		fields, err := s.infoSection(strings.ToLower(section), st)
+		if err != nil {
+			return nil, err
+		}
+		out[strings.ToLower(section)] = fields
+	}
+	return out, nil
+}
+
+func (s *Server) infoSection(section string, st kvs.Stats) ([]infoField, error) {
+	switch section {
+	case "server":
+		uptime := time.Since(s.started)
+		return []infoField{
+			{"go_version", runtime.Version()},
+			{"process_id", os.Getpid()},
+			{"uptime_in_seconds", int64(uptime.Seconds())},
+			{"uptime_in_days", int64(uptime.Hours() / 24)},
+		}, nil
+
+	case "clients":
+		return []infoField{
+			{"connected_clients", s.connections.Load()},
+			{"blocked_clients", st.BlockedClients},
+			{"pubsub_subscribers", s.pubsub.NumSubscribers()},
+		}, nil
+
+	case "memory":
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		_, policy := s.store.MaxMemory()
+		return []infoField{
+			{"used_memory", st.UsedMemory},
+			{"used_memory_human", humanBytes(st.UsedMemory)},
+			{"maxmemory", st.MaxMemory},
+			{"maxmemory_human", humanBytes(st.MaxMemory)},
+			{"maxmemory_policy", policy},
+			{"heap_alloc", mem.HeapAlloc},
+			{"heap_sys", mem.HeapSys},
+		}, nil
+
+	case "persistence":
+		var lastSave int64
+		if !st.LastSave.IsZero() {
+			lastSave = st.LastSave.Unix()
+		}
+		fields := []infoField{
+			{"loading", boolInt(s.loading.Load())},
+			{"snapshot", s.SnapshotPath()},
+			{"last_save_time", lastSave},
+			{"changes_since_last_save", st.ChangesSinceSave},
+		}
+		if log := s.store.ChangeLog(); log != nil {
+			_, next := log.Bounds()
+			fields = append(fields, infoField{"cdc_enabled", 1}, infoField{"cdc_offset", next})
+		} else {
+			fields = append(fields, infoField{"cdc_enabled", 0})
+		}
+		return fields, nil
+
+	case "stats":
+		var hitRatio float64
+		if lookups := st.Hits + st.Misses; lookups > 0 {
+			hitRatio = float64(st.Hits) / float64(lookups)
+		}
+		return []infoField{
+			{"total_commands_processed", s.metrics.total()},
+			{"keyspace_hits", st.Hits},
+			{"keyspace_misses", st.Misses},
+			{"keyspace_hit_ratio", hitRatio},
+			{"expired_keys", st.ExpiredKeys},
+			{"evicted_keys", st.EvictedKeys},
+			{"throttled_commands", s.limiter.total()},
+			{"quota_rejected_commands", s.quotaRejectedTotal()},
+		}, nil
+
+	case "keyspace":
+		return []infoField{
+			{"keys", st.Keys[kvs.TypeString] + st.Keys[kvs.TypeQueue]},
+			{"strings", st.Keys[kvs.TypeString]},
+			{"queues", st.Keys[kvs.TypeQueue]},
+			{"expires", st.Expires},
+			{"queue_depth", st.QueueDepth},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown info section: %s", section)
+}
+
+// infoJSON shapes the sections as JSON objects keyed by section.
+func infoJSON(sections map[string][]infoField) gin.H {
+	out := make(gin.H, len(sections))
+	for section, fields := range sections {
+		obj := make(gin.H, len(fields))
+		for _, f := range fields {
+			obj[f.name] = f.value
+		}
+		out[section] = obj
+	}
+	return out
+}
+
+// infoText renders the sections as Redis does: a "# Section" header followed
+// by name:value lines, sections separated by blank lines.
+func infoText(sections map[string][]infoField) string {
+	var b strings.Builder
+	for _, section := range infoSections {
+		fields, ok := sections[section]
+		if !ok {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "# %s\n", strings.ToUpper(section[:1])+section[1:])
+		for _, f := range fields {
+			fmt.Fprintf(&b, "%s:%v\n", f.name, f.value)
+		}
+	}
+	return b.String()
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// infoCommand implements INFO [section ...].
+func (s *Server) infoCommand(contents []string) Result {
+	sections, err := s.info(contents...)
+	if err != nil {
+		return Result{http.StatusBadRequest, gin.H{"error": err.Error()}}
+	}
+	return Result{http.StatusOK, gin.H{"info": infoJSON(sections)}}
+}
+
+// handleInfo serves INFO over HTTP:
+//
+//	GET /info?section=<name>&format=text|json
+//
+// section may be repeated and defaults to every section; format defaults to
+// JSON.
+func (s *Server) handleInfo(c *gin.Context) {
+	sections, err := s.info(c.QueryArray("section")...)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("format") == "text" {
+		c.String(http.StatusOK, infoText(sections))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, infoJSON(sections))
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID of a request. A client may set it to
+// correlate its own logs with the server's; otherwise one is generated. It
+// is echoed back on every response.
+const RequestIDHeader = "X-Request-ID"
+
+// Log levels accepted by SetLogLevel, from the most to the least verbose.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// SetLogLevel sets how much the server logs: debug, info, warn or error.
+// Requests are logged at the info level.
+func (s *Server) SetLogLevel(level string) error {
+	l, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return errors.New("invalid log level: " + level)
+	}
+	s.level.Set(l)
+	return nil
+}
+
+// LogLevel returns the log level in effect.
+func (s *Server) LogLevel() string {
+	return strings.ToLower(s.level.Level().String())
+}
+
+// SetLogOutput sends the logs of the server and its store to w, formatted
+// as "json" or "text". Records logged while handling a request carry its
+// request_id.
+func (s *Server) SetLogOutput(w io.Writer, format string) error {
+	opts := &slog.HandlerOptions{Level: &s.level}
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return errors.New("invalid log format: " + format)
+	}
+	logger := slog.New(requestIDHandler{h})
+	s.logger.Store(logger)
+	s.store.SetLogger(logger.With("component", "kvs"))
+	return nil
+}
+
+// Logger returns the server's logger, for the program running it to log
+// alongside.
+func (s *Server) Logger() *slog.Logger {
+	return s.logger.Load()
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDHandler adds the request ID found in the context to each record.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// requestLogger gives each request its ID, then logs it once served.
+func (s *Server) requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		ctx := context.WithValue(c.Request.Context(), requestIDKey{}, id)
+		c.Request = c.Request.WithContext(ctx)
+
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelWarn
+		}
+		s.Logger().LogAttrs(ctx, level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client", c.Request.RemoteAddr),
+			slog.Int("bytes", c.Writer.Size()),
+		)
+	}
+}
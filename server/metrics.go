@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the command latency
+// histogram buckets.
+var latencyBuckets = [...]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// metrics holds the counters served by /metrics. Gauges describing the store
+// are read from kvs.Stats when scraped instead.
+type metrics struct {
+	commands sync.Map // commandLabels -> *latency
+}
+
+// commandLabels identify the series of one command and outcome.
+type commandLabels struct {
+	command string
+	outcome string // see outcome
+}
+
+// latency is a histogram of command durations. counts[i] holds durations in
+// (latencyBuckets[i-1], latencyBuckets[i]], the last one those above every
+// bucket.
+type latency struct {
+	counts [len(latencyBuckets) + 1]atomic.Uint64 // not cumulative
+	sum    atomic.Int64                           // nanoseconds
+}
+
+// outcome labels a command's result by its status: ok, client_error or
+// server_error.
+func outcome(status int) string {
+	switch {
+	case status >= 500:
+		return "server_error"
+	case status >= 400:
+		return "client_error"
+	}
+	return "ok"
+}
+
+// observe records that command ran for d and ended with status.
+func (m *metrics) observe(command string, status int, d time.Duration) {
+	labels := commandLabels{command, outcome(status)}
+	v, ok := m.commands.Load(labels)
+	if !ok {
+		v, _ = m.commands.LoadOrStore(labels, &latency{})
+	}
+	h := v.(*latency)
+
+	i := sort.SearchFloat64s(latencyBuckets[:], d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// total returns the number of commands recorded.
+func (m *metrics) total() uint64 {
+	var n uint64
+	m.commands.Range(func(_, v any) bool {
+		h := v.(*latency)
+		for i := range h.counts {
+			n += h.counts[i].Load()
+		}
+		return true
+	})
+	return n
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func (s *Server) handleMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	s.writeMetrics(c.Writer)
+}
+
+func (s *Server) writeMetrics(out io.Writer) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	type series struct {
+		commandLabels
+		h *latency
+	}
+	var all []series
+	s.metrics.commands.Range(func(k, v any) bool {
+		all = append(all, series{k.(commandLabels), v.(*latency)})
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].command != all[j].command {
+			return all[i].command < all[j].command
+		}
+		return all[i].outcome < all[j].outcome
+	})
+
+	header(w, "kvs_commands_total", "counter", "Commands executed, by command and outcome.")
+	totals := make([]uint64, len(all))
+	for i, se := range all {
+		for j := range se.h.counts {
+			totals[i] += se.h.counts[j].Load()
+		}
+		fmt.Fprintf(w, "kvs_commands_total{command=%q,outcome=%q} %d\n", se.command, se.outcome, totals[i])
+	}
+
+	header(w, "kvs_command_duration_seconds", "histogram", "Time taken to execute commands, by command and outcome.")
+	for _, se := range all {
+		labels := fmt.Sprintf("command=%q,outcome=%q", se.command, se.outcome)
+		var cumulative uint64
+		for j, le := range latencyBuckets {
+			cumulative += se.h.counts[j].Load()
+			fmt.Fprintf(w, "kvs_command_duration_seconds_bucket{%s,le=%q} %d\n", labels, formatFloat(le), cumulative)
+		}
+		// The count is taken from the buckets, so that it stays consistent
+		// with them while commands are being recorded.
+		cumulative += se.h.counts[len(latencyBuckets)].Load()
+		fmt.Fprintf(w, "kvs_command_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, cumulative)
+		fmt.Fprintf(w, "kvs_command_duration_seconds_sum{%s} %s\n", labels, formatFloat(time.Duration(se.h.sum.Load()).Seconds()))
+		fmt.Fprintf(w, "kvs_command_duration_seconds_count{%s} %d\n", labels, cumulative)
+	}
+
+	st := s.store.Stats()
+	header(w, "kvs_keys", "gauge", "Keys in the store, by type.")
+	for _, kind := range []string{"queue", "string"} {
+		fmt.Fprintf(w, "kvs_keys{type=%q} %d\n", kind, st.Keys[kind])
+	}
+	gauge(w, "kvs_queue_depth", "Values held across all queues.", int64(st.QueueDepth))
+	gauge(w, "kvs_blocked_clients", "Clients waiting in BQPOP.", int64(st.BlockedClients))
+	counter(w, "kvs_expired_keys_total", "Keys deleted because they expired.", st.ExpiredKeys)
+	counter(w, "kvs_evicted_keys_total", "Keys evicted to stay under maxmemory.", st.EvictedKeys)
+	gauge(w, "kvs_memory_used_bytes", "Approximate memory held by the keys.", st.UsedMemory)
+	gauge(w, "kvs_memory_max_bytes", "The maxmemory limit, 0 for none.", st.MaxMemory)
+
+	header(w, "kvs_throttled_commands_total", "counter", "Commands refused by a rate limit, by limit.")
+	for _, limit := range append([]string{"global"}, rateClasses...) {
+		fmt.Fprintf(w, "kvs_throttled_commands_total{limit=%q} %d\n", limit, s.limiter.throttled[limit].Load())
+	}
+	header(w, "kvs_quota_rejected_commands_total", "counter", "Commands refused by a tenant quota, by tenant and resource.")
+	labels, counts := s.quotaRejections()
+	for i, l := range labels {
+		fmt.Fprintf(w, "kvs_quota_rejected_commands_total{tenant=%q,resource=%q} %d\n", l.Tenant, l.Resource, counts[i])
+	}
+	tenants := s.store.Tenants()
+	header(w, "kvs_tenant_keys", "gauge", "Keys of each tenant with a quota.")
+	for _, t := range tenants {
+		fmt.Fprintf(w, "kvs_tenant_keys{tenant=%q} %d\n", t.Prefix, t.Keys)
+	}
+	header(w, "kvs_tenant_memory_bytes", "gauge", "Approximate memory held by the keys of each tenant with a quota.")
+	for _, t := range tenants {
+		fmt.Fprintf(w, "kvs_tenant_memory_bytes{tenant=%q} %d\n", t.Prefix, t.Memory)
+	}
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func gauge(w io.Writer, name, help string, value int64) {
+	header(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func counter(w io.Writer, name, help string, value uint64) {
+	header(w, name, "counter", help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
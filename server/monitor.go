@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// monitorChannel is the channel of s.monitors every command is published on.
+const monitorChannel = "monitor"
+
+// monitorEvent is what a monitor receives for each command.
+type monitorEvent struct {
+	Time      float64  `json:"time"` // unix seconds
+	RequestID string   `json:"request_id,omitempty"`
+	Client    string   `json:"client"`
+	User      string   `json:"user,omitempty"`
+	Session   string   `json:"session,omitempty"`
+	Command   []string `json:"command"`
+}
+
+// monitor publishes cmd to the clients of /monitor. While nobody monitors it
+// only costs an atomic load, and it never waits for a slow monitor: one that
+// falls behind is disconnected instead.
+func (s *Server) monitor(cl *client, cmd string) {
+	if s.monitoring.Load() == 0 {
+		return
+	}
+	operation, contents := ParseCommand(cmd)
+	event := monitorEvent{
+		Time:      float64(time.Now().UnixMicro()) / 1e6,
+		RequestID: RequestID(cl.ctx),
+		Client:    cl.addr,
+		User:      cl.user,
+		Command:   append([]string{operation}, hideSecrets(operation, contents)...),
+	}
+	if cl.session != nil {
+		event.Session = cl.session.id
+	}
+	payload, _ := json.Marshal(event)
+	s.monitors.Publish(monitorChannel, string(payload))
+}
+
+// handleMonitor streams every command the server runs, as Server-Sent
+// Events, like Redis' MONITOR:
+//
+//	GET /monitor
+//
+// Each "command" event carries the time, the request ID, the client's
+// address, its session if it has one, and the command as sent. A monitor
+// that falls behind gets a final "error" event and is disconnected.
+func (s *Server) handleMonitor(c *gin.Context) {
+	sub := s.monitors.Subscribe([]string{monitorChannel}, nil, int(s.subscriberBuffer.Load()))
+	s.monitoring.Add(1)
+	defer func() {
+		s.monitoring.Add(-1)
+		s.monitors.Unsubscribe(sub)
+	}()
+
+	ping := time.NewTicker(ssePingInterval)
+	defer ping.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.SSEvent("monitor", "OK")
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case msg := <-sub.C:
+			c.SSEvent("command", msg.Payload)
+			return true
+		case <-ping.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-sub.Done():
+			if err := sub.Err(); err != nil {
+				c.SSEvent("error", err.Error())
+			}
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultSubscriberBuffer is how many messages may wait for a subscriber
+// before it is disconnected as too slow, unless changed with CONFIG SET
+// subscriber-buffer.
+const DefaultSubscriberBuffer = 128
+
+// ssePingInterval keeps idle subscriber connections from being closed by
+// proxies along the way.
+const ssePingInterval = 30 * time.Second
+
+// handleSubscribe streams messages as Server-Sent Events:
+//
+//	GET /subscribe?channel=news&channel=alerts&pattern=user.*
+//
+// channel subscribes to a channel by name (SUBSCRIBE), pattern to every
+// channel matching a glob (PSUBSCRIBE). Both may be repeated, within the
+// channels the user's ACL allows. A subscriber that falls behind gets a
+// final "error" event and is disconnected.
+func (s *Server) handleSubscribe(c *gin.Context) {
+	channels := c.QueryArray("channel")
+	patterns := c.QueryArray("pattern")
+	if len(channels) == 0 && len(patterns) == 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "at least one channel or pattern is required"})
+		return
+	}
+	name := authUser(c.Request.Context())
+	u := s.aclUser(name)
+	for _, channel := range channels {
+		if !u.canAccessChannel(channel) {
+			res := noPermission(name, "access channel "+channel)
+			c.IndentedJSON(res.Status, res.Body)
+			return
+		}
+	}
+	for _, pattern := range patterns {
+		if !u.canSubscribePattern(pattern) {
+			res := noPermission(name, "access channel pattern "+pattern)
+			c.IndentedJSON(res.Status, res.Body)
+			return
+		}
+	}
+
+	sub := s.pubsub.Subscribe(channels, patterns, int(s.subscriberBuffer.Load()))
+	defer s.pubsub.Unsubscribe(sub)
+
+	ping := time.NewTicker(ssePingInterval)
+	defer ping.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.SSEvent("subscribe", gin.H{"channels": channels, "patterns": patterns})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case msg := <-sub.C:
+			c.SSEvent("message", msg)
+			return true
+		case <-ping.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-sub.Done():
+			if err := sub.Err(); err != nil {
+				c.SSEvent("error", err.Error())
+			}
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
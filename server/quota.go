@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SinisterSup/kv-datastore/kvs"
+)
+
+// tenantQuota caps the keys starting with a prefix. Zero means no limit.
+type tenantQuota struct {
+	keys   int64
+	memory int64 // approximate bytes, as counted by the store
+}
+
+// quotas are the tenant quotas in effect, by prefix.
+type quotas map[string]tenantQuota
+
+// parseQuotas parses tenant-quotas: a comma-separated list of
+// "<prefix>=<keys>/<memory>", such as "billing:=1000/64mb,jobs:=0/1gb".
+func parseQuotas(spec string) (quotas, error) {
+	q := make(quotas)
+	for _, entry := range strings.Split(spec, ",") {
+		if entry == "" {
+			continue
+		}
+		invalid := errors.New("invalid tenant quota: " + entry)
+		prefix, limits, ok := strings.Cut(entry, "=")
+		keys, memory, ok2 := strings.Cut(limits, "/")
+		if !ok || !ok2 || prefix == "" {
+			return nil, invalid
+		}
+		if _, ok := q[prefix]; ok {
+			return nil, errors.New("duplicate tenant quota: " + prefix)
+		}
+		maxKeys, err := strconv.ParseInt(keys, 10, 64)
+		if err != nil || maxKeys < 0 {
+			return nil, invalid
+		}
+		maxMemory, err := kvs.ParseMemory(memory)
+		if err != nil {
+			return nil, invalid
+		}
+		q[prefix] = tenantQuota{maxKeys, maxMemory}
+	}
+	return q, nil
+}
+
+// String formats q as parseQuotas reads it, ordered by prefix.
+func (q quotas) String() string {
+	var parts []string
+	for _, prefix := range sortedKeys(q) {
+		parts = append(parts, fmt.Sprintf("%s=%d/%d", prefix, q[prefix].keys, q[prefix].memory))
+	}
+	return strings.Join(parts, ",")
+}
+
+// SetTenantQuotas limits the keys and memory of each tenant, a tenant being
+// the keys that start with a prefix, see parseQuotas. The store refuses
+// writes that would take a tenant over its key or memory quota, see
+// kvs.KeyValueStore.SetTenantQuotas, which run answers with 507 Insufficient
+// Storage.
+func (s *Server) SetTenantQuotas(spec string) error {
+	q, err := parseQuotas(spec)
+	if err != nil {
+		return err
+	}
+	s.quotasMu.Lock()
+	defer s.quotasMu.Unlock()
+	limits := make([]kvs.TenantQuota, 0, len(q))
+	for _, prefix := range sortedKeys(q) {
+		limits = append(limits, kvs.TenantQuota{Prefix: prefix, Keys: q[prefix].keys, Memory: q[prefix].memory})
+	}
+	s.store.SetTenantQuotas(limits)
+	s.quotas.Store(&q)
+	return nil
+}
+
+// TenantQuotas returns the quotas set by SetTenantQuotas.
+func (s *Server) TenantQuotas() string {
+	return s.quotas.Load().String()
+}
+
+// quotaRejections returns kvs_quota_rejected_commands_total, ordered by
+// tenant and resource.
+func (s *Server) quotaRejections() ([]kvs.QuotaError, []uint64) {
+	rejections := s.store.QuotaRejections()
+	labels := make([]kvs.QuotaError, 0, len(rejections))
+	for l := range rejections {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Tenant != labels[j].Tenant {
+			return labels[i].Tenant < labels[j].Tenant
+		}
+		return labels[i].Resource < labels[j].Resource
+	})
+	counts := make([]uint64, len(labels))
+	for i, l := range labels {
+		counts[i] = rejections[l]
+	}
+	return labels, counts
+}
+
+// quotaRejectedTotal returns the commands refused by any quota.
+func (s *Server) quotaRejectedTotal() uint64 {
+	var n uint64
+	for _, c := range s.store.QuotaRejections() {
+		n += c
+	}
+	return n
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateClasses are the command categories that can be limited on their own,
+// besides the limit on all commands, which is named "global".
+var rateClasses = []string{"read", "write", "queue", "admin"}
+
+// rateLimit lets a client run rate commands a second on average, and up to
+// burst at once. The zero value does not limit anything.
+type rateLimit struct {
+	rate  float64
+	burst float64
+	spec  string // as set
+}
+
+var rateUnits = map[string]float64{"s": 1, "m": 60, "h": 3600}
+
+// parseRateLimit parses a limit written "<n>/<s|m|h>[:<burst>]", such as
+// "100/s" or "1000/m:50". The burst defaults to n. An empty limit or "0"
+// turns it off.
+func parseRateLimit(spec string) (rateLimit, error) {
+	if spec == "" || spec == "0" {
+		return rateLimit{}, nil
+	}
+	invalid := errors.New("invalid rate limit: " + spec)
+	rate, burst, hasBurst := strings.Cut(spec, ":")
+	n, unit, ok := strings.Cut(rate, "/")
+	count, err := strconv.ParseFloat(n, 64)
+	if !ok || err != nil || count <= 0 || math.IsInf(count, 0) || rateUnits[unit] == 0 {
+		return rateLimit{}, invalid
+	}
+	l := rateLimit{rate: count / rateUnits[unit], burst: count, spec: spec}
+	if hasBurst {
+		b, err := strconv.Atoi(burst)
+		if err != nil || b < 1 {
+			return rateLimit{}, invalid
+		}
+		l.burst = float64(b)
+	}
+	// A burst below one would never let a command through.
+	l.burst = math.Max(l.burst, 1)
+	return l, nil
+}
+
+// bucket is the token bucket of one client for one limit.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (b *bucket) refill(l rateLimit, now time.Time) {
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+}
+
+type bucketKey struct {
+	client string
+	limit  string
+}
+
+// limiterShards is the number of independently locked sets of buckets, so
+// that busy clients do not hold up the others.
+const limiterShards = 32
+
+// bucketShard holds the buckets of the clients hashed to it.
+type bucketShard struct {
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+// rateLimiter keeps a token bucket per client and limit.
+type rateLimiter struct {
+	limits    map[string]*atomic.Pointer[rateLimit] // by limit name
+	throttled map[string]*atomic.Uint64             // commands refused, by limit name
+	shards    [limiterShards]bucketShard
+}
+
+func newRateLimiter() *rateLimiter {
+	r := &rateLimiter{
+		limits:    make(map[string]*atomic.Pointer[rateLimit]),
+		throttled: make(map[string]*atomic.Uint64),
+	}
+	for _, name := range append([]string{"global"}, rateClasses...) {
+		r.limits[name] = new(atomic.Pointer[rateLimit])
+		r.limits[name].Store(&rateLimit{})
+		r.throttled[name] = new(atomic.Uint64)
+	}
+	for i := range r.shards {
+		r.shards[i].buckets = make(map[bucketKey]*bucket)
+	}
+	return r
+}
+
+// total returns the commands refused by any limit.
+func (r *rateLimiter) total() uint64 {
+	var n uint64
+	for _, throttled := range r.throttled {
+		n += throttled.Load()
+	}
+	return n
+}
+
+func (r *rateLimiter) shardFor(client string) *bucketShard {
+	h := fnv.New32a()
+	h.Write([]byte(client))
+	return &r.shards[h.Sum32()%limiterShards]
+}
+
+// sweepInterval is how often buckets that refilled are dropped, so that
+// clients that went away are forgotten.
+const sweepInterval = time.Minute
+
+// take spends a token of the global limit and one of the limit of class,
+// if any, for client. If either is empty it spends none, and returns the
+// name of the limit and how long until it has a token again.
+func (r *rateLimiter) take(client, class string, now time.Time) (string, time.Duration, bool) {
+	type limited struct {
+		name  string
+		limit rateLimit
+	}
+	var active []limited
+	for _, name := range []string{"global", class} {
+		if p, ok := r.limits[name]; ok {
+			if l := *p.Load(); l.rate != 0 {
+				active = append(active, limited{name, l})
+			}
+		}
+	}
+	if len(active) == 0 {
+		return "", 0, true // the default, which must stay cheap
+	}
+
+	sh := r.shardFor(client)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if now.Sub(sh.lastSweep) >= sweepInterval {
+		r.sweep(sh, now)
+	}
+
+	var spend []*bucket
+	for _, a := range active {
+		key := bucketKey{client, a.name}
+		b, ok := sh.buckets[key]
+		if !ok {
+			b = &bucket{tokens: a.limit.burst, last: now}
+			sh.buckets[key] = b
+		}
+		b.refill(a.limit, now)
+		if b.tokens < 1 {
+			r.throttled[a.name].Add(1)
+			wait := time.Duration((1 - b.tokens) / a.limit.rate * float64(time.Second))
+			return a.name, wait, false
+		}
+		spend = append(spend, b)
+	}
+	for _, b := range spend {
+		b.tokens--
+	}
+	return "", 0, true
+}
+
+// sweep drops the buckets of sh that are full again. The caller must hold
+// sh.mu.
+func (r *rateLimiter) sweep(sh *bucketShard, now time.Time) {
+	for key, b := range sh.buckets {
+		l := *r.limits[key.limit].Load()
+		b.refill(l, now)
+		if l.rate == 0 || b.tokens >= l.burst {
+			delete(sh.buckets, key)
+		}
+	}
+	sh.lastSweep = now
+}
+
+// set changes the limit called name. Buckets keep their tokens, capped to
+// the new burst when next used.
+func (r *rateLimiter) set(name, spec string) error {
+	l, err := parseRateLimit(spec)
+	if err != nil {
+		return err
+	}
+	r.limits[name].Store(&l)
+	return nil
+}
+
+func (r *rateLimiter) get(name string) string {
+	spec := r.limits[name].Load().spec
+	if spec == "" {
+		return "0"
+	}
+	return spec
+}
+
+// registerRateLimitConfig exposes the limits as "ratelimit", for all
+// commands, and "ratelimit-<class>" for each of rateClasses.
+func (s *Server) registerRateLimitConfig() {
+	s.RegisterConfig("ratelimit",
+		func() string { return s.limiter.get("global") },
+		func(v string) error { return s.limiter.set("global", v) })
+	for _, class := range rateClasses {
+		class := class
+		s.RegisterConfig("ratelimit-"+class,
+			func() string { return s.limiter.get(class) },
+			func(v string) error { return s.limiter.set(class, v) })
+	}
+}
+
+// rateLimitClient returns who a request is limited as: the user it
+// authenticated as, or else the IP address it came from.
+func rateLimitClient(user, addr string) string {
+	if user != "" {
+		return "user:" + user
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "ip:" + addr
+}
+
+// throttle spends a token of the limits cmd counts against for the client,
+// or refuses it with 429 Too Many Requests.
+func (s *Server) throttle(user, addr, cmd string) (Result, bool) {
+	limit, wait, ok := s.limiter.take(rateLimitClient(user, addr), commandCategory[cmd], time.Now())
+	if ok {
+		return Result{}, true
+	}
+	return Result{http.StatusTooManyRequests, gin.H{
+		"error":       fmt.Sprintf("rate limit exceeded (%s)", limit),
+		"retry_after": retryAfter(wait),
+	}}, false
+}
+
+// retryAfter rounds wait up to whole seconds, for the Retry-After header.
+func retryAfter(wait time.Duration) int {
+	return int(math.Max(1, math.Ceil(wait.Seconds())))
+}
+
+// setRetryAfter sends the Retry-After header of a 429 response.
+func setRetryAfter(c *gin.Context, res Result) {
+	if wait, ok := res.Body["retry_after"].(int); ok {
+		c.Header("Retry-After", strconv.Itoa(wait))
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/handle"
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/gin-gonic/gin"
+)
+
+// Command is the JSON body accepted by the command endpoints. Either a single
+// command or, on POST, a pipeline of commands may be sent.
+type Command struct {
+	Cmnd  string   `json:"command"`
+	Cmnds []string `json:"commands"`
+}
+
+// Server exposes a KeyValueStore over the JSON REST API.
+type Server struct {
+	store    *kvs.KeyValueStore
+	pubsub   *kvs.PubSub
+	router   *gin.Engine
+	sessions *sessionTable
+
+	// txMu makes EXEC atomic: every other command holds it for reading
+	// while it runs, and EXEC holds it for writing.
+	txMu sync.RWMutex
+
+	// Runtime settings, see config.go.
+	params           map[string]param
+	subscriberBuffer atomic.Int64
+
+	// Logging, see logging.go and audit.go.
+	level  slog.LevelVar
+	logger atomic.Pointer[slog.Logger]
+	audit  atomic.Pointer[slog.Logger] // nil while the audit log is off
+	redact atomic.Pointer[[]string]    // glob patterns
+
+	// Shutdown, see shutdown.go. baseCtx is the parent of every request's
+	// context and is cancelled once a shutdown starts.
+	baseCtx         context.Context
+	cancelBase      context.CancelFunc
+	closing         atomic.Bool
+	shutdownCh      chan string
+	snapshotPath    atomic.Value // string
+	shutdownTimeout atomic.Int64 // time.Duration
+
+	// Observability, see metrics.go, slowlog.go, monitor.go and info.go.
+	metrics     metrics
+	slowlog     *slowlog
+	monitors    *kvs.PubSub  // see monitor.go
+	monitoring  atomic.Int32 // clients of /monitor
+	started     time.Time
+	connections atomic.Int64 // open client connections, when run by Serve
+
+	// Readiness, see health.go.
+	loading atomic.Bool
+	checks  []readinessCheck
+
+	// Authentication, see auth.go. creds is nil while anyone may connect.
+	authMu   sync.Mutex   // serializes loading the auth file
+	authPath atomic.Value // string
+	creds    atomic.Pointer[credentials]
+	tls      *tlsFiles // nil to serve plain HTTP, see tls.go
+
+	// Rate limits and tenant quotas, see ratelimit.go and quota.go.
+	limiter  *rateLimiter
+	quotasMu sync.Mutex // serializes SetTenantQuotas
+	quotas   atomic.Pointer[quotas]
+}
+
+func New(store *kvs.KeyValueStore) *Server {
+	s := &Server{
+		store:      store,
+		pubsub:     kvs.NewPubSub(),
+		router:     gin.New(),
+		sessions:   newSessionTable(),
+		params:     make(map[string]param),
+		shutdownCh: make(chan string, 1),
+		started:    time.Now(),
+		slowlog:    newSlowlog(),
+		monitors:   kvs.NewPubSub(),
+		limiter:    newRateLimiter(),
+	}
+	s.baseCtx, s.cancelBase = context.WithCancel(context.Background())
+	s.subscriberBuffer.Store(DefaultSubscriberBuffer)
+	s.SetLogOutput(os.Stderr, "json")
+	s.SetAuditRedact(DefaultAuditRedact)
+	s.snapshotPath.Store("")
+	s.authPath.Store("")
+	s.shutdownTimeout.Store(int64(DefaultShutdownTimeout))
+	s.quotas.Store(&quotas{})
+	s.registerDefaultConfig()
+
+	// Probes are answered while loading and shutting down, and not logged.
+	s.router.GET("/healthz", s.handleHealthz)
+	s.router.GET("/readyz", s.handleReadyz)
+
+	api := s.router.Group("/", s.requestLogger(), gin.Recovery(), s.refuseWhileClosing(), s.authenticate())
+
+	api.GET("/metrics", s.allow("METRICS"), s.handleMetrics)
+	api.GET("/info", s.allow("INFO"), s.handleInfo)
+	api.GET("/monitor", s.allow("MONITOR"), s.handleMonitor)
+
+	// Commands are authorized by execute, as AUTH must get through.
+	data := api.Group("/", s.refuseWhileLoading())
+	data.POST("/", s.handlePost)
+	data.GET("/", s.handleGet)
+	data.GET("/scan", s.allow("SCAN"), s.handleScan)
+	data.GET("/subscribe", s.allow("SUBSCRIBE"), s.handleSubscribe)
+	data.GET("/changes", s.allow("CHANGES"), s.handleChanges)
+
+	return s
+}
+
+// SetNotifications enables keyspace notifications for the given
+// notify-keyspace-events letters, delivered to subscribers of /subscribe.
+func (s *Server) SetNotifications(spec string) error {
+	return s.store.SetNotifications(s.pubsub, spec)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// withClient resolves the session named by SessionHeader, if any, and runs
+// fn with it locked. The session token is echoed back on the response.
+func (s *Server) withClient(c *gin.Context, fn func(cl *client)) {
+	cl := &client{ctx: c.Request.Context(), addr: c.Request.RemoteAddr, user: authUser(c.Request.Context())}
+	if id := c.GetHeader(SessionHeader); id != "" {
+		sess, ok := s.sessions.get(id)
+		if !ok {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid or expired session"})
+			return
+		}
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		cl.session = sess
+	}
+
+	fn(cl)
+}
+
+// respond writes res, adding the session header when cl has a session.
+func respond(c *gin.Context, cl *client, res Result) {
+	if cl.session != nil {
+		c.Header(SessionHeader, cl.session.id)
+	}
+	if res.Status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", authChallenge)
+	}
+	setRetryAfter(c, res)
+	c.IndentedJSON(res.Status, res.Body)
+}
+
+func (s *Server) handlePost(c *gin.Context) {
+	var cmd Command
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.withClient(c, func(cl *client) {
+		if cmd.Cmnds != nil {
+			s.handlePipeline(c, cl, cmd.Cmnds)
+			return
+		}
+		command, err := conditionalSet(c, cmd.Cmnd)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		res := s.execute(cl, command, writeCommands)
+		setETag(c, res)
+		respond(c, cl, res)
+	})
+}
+
+func (s *Server) handleGet(c *gin.Context) {
+	var getcmd Command
+	if err := c.ShouldBindJSON(&getcmd); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.withClient(c, func(cl *client) {
+		if res, ok := readOnly(getcmd.Cmnd); !ok {
+			respond(c, cl, res)
+			return
+		}
+		res := s.execute(cl, getcmd.Cmnd, readCommands)
+		if setETag(c, res) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		respond(c, cl, res)
+	})
+}
+
+// handlePipeline runs the commands in order and answers with one result per
+// command. Each result carries its own status, so a failing command does not
+// stop the ones after it and the response itself is always 200.
+func (s *Server) handlePipeline(c *gin.Context, cl *client, commands []string) {
+	results := make([]gin.H, len(commands))
+	for i, cmd := range commands {
+		results[i] = withStatus(s.execute(cl, cmd, writeCommands, readCommands))
+	}
+	respond(c, cl, Result{http.StatusOK, gin.H{"results": results}})
+}
+
+// Pageable form of SCAN: GET /scan?cursor=0&match=user:*&count=100&type=string
+// Keep following the returned cursor until it comes back as "0".
+func (s *Server) handleScan(c *gin.Context) {
+	parts := []string{c.DefaultQuery("cursor", "0")}
+	for _, opt := range []string{"match", "count", "type"} {
+		if val, ok := c.GetQuery(opt); ok {
+			parts = append(parts, strings.ToUpper(opt), val)
+		}
+	}
+
+	cursor, keys, _, err := handle.ScanHandler(parts, s.store)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	res := Result{http.StatusOK, gin.H{"cursor": cursor, "keys": keys}}
+	s.filterKeys(&client{user: authUser(c.Request.Context())}, "SCAN", res)
+	c.IndentedJSON(res.Status, res.Body)
+}
@@ -0,0 +1,1272 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SinisterSup/kv-datastore/kvs"
+	"github.com/SinisterSup/kv-datastore/server"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newServer() *server.Server {
+	return server.New(kvs.NewKeyValueStore())
+}
+
+func do(t *testing.T, s http.Handler, method, body string) (int, map[string]interface{}) {
+	t.Helper()
+	status, resp, _ := doSession(t, s, method, body, "")
+	return status, resp
+}
+
+// doSession sends body as part of the given session, if any, and returns the
+// session token from the response alongside the status and body.
+func doSession(t *testing.T, s http.Handler, method, body, session string) (int, map[string]interface{}, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if session != "" {
+		req.Header.Set(server.SessionHeader, session)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp, rec.Header().Get(server.SessionHeader)
+}
+
+func TestCommandRoutes(t *testing.T) {
+	s := newServer()
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"Set", http.MethodPost, `{"command": "SET hello world"}`, http.StatusOK},
+		{"Get", http.MethodGet, `{"command": "GET hello"}`, http.StatusOK},
+		{"Unknown Key", http.MethodGet, `{"command": "GET missing"}`, http.StatusNotFound},
+		{"Read on POST", http.MethodPost, `{"command": "GET hello"}`, http.StatusBadRequest},
+		{"Write on GET", http.MethodGet, `{"command": "SET a b"}`, http.StatusBadRequest},
+		{"Config Get on GET", http.MethodGet, `{"command": "CONFIG GET maxmemory"}`, http.StatusOK},
+		{"Config Set on GET", http.MethodGet, `{"command": "CONFIG SET maxmemory 1"}`, http.StatusBadRequest},
+		{"Slowlog Reset on GET", http.MethodGet, `{"command": "SLOWLOG RESET"}`, http.StatusBadRequest},
+		{"ACL Setuser on GET", http.MethodGet, `{"command": "ACL SETUSER bob on"}`, http.StatusBadRequest},
+		{"Config Set on POST", http.MethodPost, `{"command": "CONFIG SET maxmemory 0"}`, http.StatusOK},
+		{"Wrong Type", http.MethodPost, `{"command": "QPUSH hello 1"}`, http.StatusBadRequest},
+		{"Invalid JSON", http.MethodPost, `{"command": `, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, resp := do(t, s, test.method, test.body)
+			if status != test.status {
+				t.Errorf("Expected: %v, but Got: %v %v", test.status, status, resp)
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	s := newServer()
+
+	status, resp := do(t, s, http.MethodPost, `{"commands": ["SET a 1", "QPUSH q x", "GET a", "GET missing", "BOGUS"]}`)
+	if status != http.StatusOK {
+		t.Fatalf("Expected: %v, but Got: %v", http.StatusOK, status)
+	}
+
+	results, ok := resp["results"].([]interface{})
+	if !ok || len(results) != 5 {
+		t.Fatalf("Expected 5 results, but Got: %v", resp)
+	}
+
+	expected := []struct {
+		status float64
+		field  string
+		value  string
+	}{
+		{http.StatusOK, "message", "value set for key: a"},
+		{http.StatusOK, "message", "values pushed to queue"},
+		{http.StatusOK, "value", "1"},
+		{http.StatusNotFound, "error", "key not found"},
+		{http.StatusBadRequest, "error", "invalid command"},
+	}
+	for i, e := range expected {
+		res := results[i].(map[string]interface{})
+		if res["status"] != e.status || res[e.field] != e.value {
+			t.Errorf("Result %d: Expected: %v %v=%v, but Got: %v", i, e.status, e.field, e.value, res)
+		}
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	s := newServer()
+	do(t, s, http.MethodPost, `{"command": "SET balance 10"}`)
+
+	status, _, session := doSession(t, s, http.MethodPost, `{"command": "MULTI"}`, "")
+	if status != http.StatusOK || session == "" {
+		t.Fatalf("MULTI: Expected: %v and a session, but Got: %v %q", http.StatusOK, status, session)
+	}
+	if status, _, _ := doSession(t, s, http.MethodPost, `{"command": "SET balance 20"}`, session); status != http.StatusAccepted {
+		t.Errorf("Queue SET: Expected: %v, but Got: %v", http.StatusAccepted, status)
+	}
+	if status, _, _ := doSession(t, s, http.MethodGet, `{"command": "GET balance"}`, session); status != http.StatusAccepted {
+		t.Errorf("Queue GET: Expected: %v, but Got: %v", http.StatusAccepted, status)
+	}
+
+	// Nothing runs before EXEC.
+	if _, resp := do(t, s, http.MethodGet, `{"command": "GET balance"}`); resp["value"] != "10" {
+		t.Errorf("Expected the queued SET not to run before EXEC, but Got: %v", resp)
+	}
+
+	status, resp, _ := doSession(t, s, http.MethodPost, `{"command": "EXEC"}`, session)
+	results, _ := resp["results"].([]interface{})
+	if status != http.StatusOK || len(results) != 2 || results[1].(map[string]interface{})["value"] != "20" {
+		t.Errorf("EXEC: Expected: %v with 2 results, but Got: %v %v", http.StatusOK, status, resp)
+	}
+
+	if status, _, _ := doSession(t, s, http.MethodPost, `{"command": "EXEC"}`, session); status != http.StatusBadRequest {
+		t.Errorf("EXEC without MULTI: Expected: %v, but Got: %v", http.StatusBadRequest, status)
+	}
+	if status, _, _ := doSession(t, s, http.MethodPost, `{"command": "EXEC"}`, "unknown"); status != http.StatusBadRequest {
+		t.Errorf("Unknown session: Expected: %v, but Got: %v", http.StatusBadRequest, status)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := newServer()
+	do(t, s, http.MethodPost, `{"command": "SET balance 10"}`)
+
+	// A pipeline keeps one session across its commands.
+	_, resp, session := doSession(t, s, http.MethodPost, `{"commands": ["WATCH balance", "MULTI", "SET balance 11"]}`, "")
+	if session == "" {
+		t.Fatalf("Expected a session, but Got: %v", resp)
+	}
+
+	// Another client changes the watched key, so EXEC must abort.
+	do(t, s, http.MethodPost, `{"command": "SET balance 50"}`)
+
+	status, resp, _ := doSession(t, s, http.MethodPost, `{"command": "EXEC"}`, session)
+	if status != http.StatusConflict || resp["results"] != nil {
+		t.Errorf("EXEC: Expected: %v with no results, but Got: %v %v", http.StatusConflict, status, resp)
+	}
+	if _, resp := do(t, s, http.MethodGet, `{"command": "GET balance"}`); resp["value"] != "50" {
+		t.Errorf("Expected the aborted transaction not to run, but Got: %v", resp)
+	}
+
+	// Without interference the same transaction goes through.
+	doSession(t, s, http.MethodPost, `{"commands": ["WATCH balance", "MULTI", "SET balance 51", "EXEC"]}`, "")
+	if _, resp := do(t, s, http.MethodGet, `{"command": "GET balance"}`); resp["value"] != "51" {
+		t.Errorf("Expected the transaction to run, but Got: %v", resp)
+	}
+}
+
+func TestETags(t *testing.T) {
+	s := newServer()
+
+	send := func(method, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(http.MethodPost, `{"command": "SET key v1"}`, "If-None-Match", "*"); rec.Code != http.StatusOK {
+		t.Fatalf("Create: Expected: %v, but Got: %v", http.StatusOK, rec.Code)
+	}
+
+	rec := send(http.MethodGet, `{"command": "GET key"}`)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET: Expected: %v with an ETag, but Got: %v %q", http.StatusOK, rec.Code, etag)
+	}
+	if rec := send(http.MethodGet, `{"command": "GET key"}`, "If-None-Match", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("GET If-None-Match: Expected: %v, but Got: %v", http.StatusNotModified, rec.Code)
+	}
+
+	rec = send(http.MethodPost, `{"command": "SET key v2"}`, "If-Match", etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("SET If-Match: Expected: %v with a new ETag, but Got: %v %q", http.StatusOK, rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := send(http.MethodPost, `{"command": "SET key v3"}`, "If-Match", etag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("SET stale If-Match: Expected: %v, but Got: %v", http.StatusPreconditionFailed, rec.Code)
+	}
+	if rec := send(http.MethodPost, `{"command": "SET key v3"}`, "If-Match", "garbage"); rec.Code != http.StatusBadRequest {
+		t.Errorf("SET bad If-Match: Expected: %v, but Got: %v", http.StatusBadRequest, rec.Code)
+	}
+	if _, resp := do(t, s, http.MethodGet, `{"command": "GET key"}`); resp["value"] != "v2" {
+		t.Errorf("Expected: v2, but Got: %v", resp)
+	}
+
+	// The headers add NX, XX or IFVERSION, which NX and XX can not be
+	// combined with.
+	etag = send(http.MethodGet, `{"command": "GET key"}`).Header().Get("ETag")
+	for _, header := range [][]string{{"If-Match", etag}, {"If-Match", "*"}, {"If-None-Match", "*"}} {
+		if rec := send(http.MethodPost, `{"command": "SET key v3 EX 100 NX"}`, header...); rec.Code != http.StatusBadRequest {
+			t.Errorf("SET NX %v: Expected: %v, but Got: %v %s", header, http.StatusBadRequest, rec.Code, rec.Body)
+		}
+		if rec := send(http.MethodPost, `{"command": "SET key v3 XX"}`, header...); rec.Code != http.StatusBadRequest {
+			t.Errorf("SET XX %v: Expected: %v, but Got: %v %s", header, http.StatusBadRequest, rec.Code, rec.Body)
+		}
+	}
+	if rec := send(http.MethodPost, `{"command": "SET key v3 EX 100"}`, "If-Match", etag); rec.Code != http.StatusOK {
+		t.Errorf("SET EX If-Match: Expected: %v, but Got: %v %s", http.StatusOK, rec.Code, rec.Body)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := newServer()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/subscribe?channel=news&pattern=user.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Expected an event stream, but Got: %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func(prefix string) string {
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), prefix) {
+				return strings.TrimPrefix(lines.Text(), prefix)
+			}
+		}
+		t.Fatalf("stream ended while waiting for %q", prefix)
+		return ""
+	}
+	next("event:subscribe")
+
+	_, published := do(t, s, http.MethodPost, `{"command": "PUBLISH user.42 signed in"}`)
+	if published["receivers"] != float64(1) {
+		t.Errorf("PUBLISH: Expected 1 receiver, but Got: %v", published)
+	}
+
+	next("event:message")
+	var msg map[string]string
+	if err := json.Unmarshal([]byte(next("data:")), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["channel"] != "user.42" || msg["pattern"] != "user.*" || msg["message"] != "signed in" {
+		t.Errorf("Expected the published message, but Got: %v", msg)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	store := kvs.NewKeyValueStore()
+	store.EnableChangeLog(100)
+	s := server.New(store)
+
+	get := func(query string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodGet, "/changes?"+query, nil)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	// writeLater runs cmd shortly, for a long-poll to wait on. It runs on
+	// another goroutine, which must not call t.Fatal, so its status is sent
+	// back to be checked here.
+	writeLater := func(cmd string) <-chan int {
+		written := make(chan int, 1)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"command": "`+cmd+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			written <- rec.Code
+		}()
+		return written
+	}
+
+	// Offset 0 long-polls like any other while nothing is retained.
+	written := writeLater("SET a 1")
+	status, resp := get("offset=0&wait=5s")
+	changes, _ := resp["changes"].([]interface{})
+	if status != http.StatusOK || len(changes) != 1 || changes[0].(map[string]interface{})["op"] != "set" {
+		t.Errorf("Expected: the set change, but Got: %v %v", status, resp)
+	}
+	if status := <-written; status != http.StatusOK {
+		t.Errorf("SET: Expected: %v, but Got: %v", http.StatusOK, status)
+	}
+
+	do(t, s, http.MethodPost, `{"command": "DEL a"}`)
+
+	status, resp = get("offset=0")
+	changes, _ = resp["changes"].([]interface{})
+	if status != http.StatusOK || len(changes) != 2 || resp["next"] != float64(3) {
+		t.Fatalf("Expected: 2 changes and next 3, but Got: %v %v", status, resp)
+	}
+
+	// Long-poll: the request waits for the next change.
+	written = writeLater("QPUSH q x")
+	status, resp = get("offset=3&wait=5s")
+	changes, _ = resp["changes"].([]interface{})
+	if status != http.StatusOK || len(changes) != 1 || changes[0].(map[string]interface{})["op"] != "qpush" {
+		t.Errorf("Expected: the qpush change, but Got: %v %v", status, resp)
+	}
+	if status := <-written; status != http.StatusOK {
+		t.Errorf("QPUSH: Expected: %v, but Got: %v", http.StatusOK, status)
+	}
+
+	if status, _ := get("offset=abc"); status != http.StatusBadRequest {
+		t.Errorf("Expected: %v, but Got: %v", http.StatusBadRequest, status)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	store := kvs.NewKeyValueStore()
+	s := server.New(store)
+
+	status, resp := do(t, s, http.MethodGet, `{"command": "CONFIG GET *ttl"}`)
+	config, _ := resp["config"].(map[string]interface{})
+	if status != http.StatusOK || len(config) != 2 || config["queue-ttl"] != "24h0m0s" {
+		t.Fatalf("Expected: the two TTL settings, but Got: %v %v", status, resp)
+	}
+
+	if status, resp := do(t, s, http.MethodPost, `{"command": "CONFIG SET default-ttl 10s"}`); status != http.StatusOK {
+		t.Fatalf("Expected: %v, but Got: %v %v", http.StatusOK, status, resp)
+	}
+	if store.DefaultTTL() != 10*time.Second {
+		t.Errorf("Expected: default TTL 10s, but Got: %v", store.DefaultTTL())
+	}
+
+	do(t, s, http.MethodPost, `{"command": "CONFIG SET maxmemory 1mb"}`)
+	do(t, s, http.MethodPost, `{"command": "CONFIG SET maxmemory-policy allkeys-lru"}`)
+	if limit, policy := store.MaxMemory(); limit != 1<<20 || policy != kvs.PolicyAllKeysLRU {
+		t.Errorf("Expected: 1mb and allkeys-lru, but Got: %v %v", limit, policy)
+	}
+
+	s.RegisterConfig("listen", func() string { return ":8080" }, nil)
+	for _, cmd := range []string{"CONFIG SET listen :9000", "CONFIG SET nosuch 1", "CONFIG SET maxmemory-policy nosuch", "CONFIG SET queue-ttl", "CONFIG SET queue-buffer 0",
+		`CONFIG SET auth-file \"\"`, "CONFIG SET snapshot /tmp/dump.jsonl"} {
+		if status, _ := do(t, s, http.MethodPost, `{"command": "`+cmd+`"}`); status != http.StatusBadRequest {
+			t.Errorf("%v: Expected: %v, but Got: %v", cmd, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	store := kvs.NewKeyValueStore()
+	s := server.New(store)
+	path := filepath.Join(t.TempDir(), "dump.jsonl")
+	s.SetSnapshotPath(path)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	url := "http://" + l.Addr().String() + "/"
+
+	send := func(method, body string) (*http.Response, error) {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return http.DefaultClient.Do(req)
+	}
+
+	resp, err := send(http.MethodPost, `{"command": "SET key value"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	blocked := make(chan int, 1)
+	go func() {
+		resp, err := send(http.MethodGet, `{"command": "BQPOP queue 30"}`)
+		if err != nil {
+			blocked <- 0
+			return
+		}
+		resp.Body.Close()
+		blocked <- resp.StatusCode
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err = send(http.MethodPost, `{"command": "SHUTDOWN SAVE"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("SHUTDOWN: Expected: %v, but Got: %v", http.StatusOK, resp.StatusCode)
+	}
+
+	select {
+	case status := <-blocked:
+		if status != http.StatusServiceUnavailable {
+			t.Errorf("BQPOP: Expected: %v, but Got: %v", http.StatusServiceUnavailable, status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BQPOP was not woken by the shutdown")
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Serve: Expected: nil, but Got: %v", err)
+	}
+
+	if _, err := send(http.MethodGet, `{"command": "GET key"}`); err == nil {
+		t.Errorf("Expected: no new connections after shutdown, but Got: a response")
+	}
+	loaded := kvs.NewKeyValueStore()
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := loaded.Get("key"); val != "value" {
+		t.Errorf("Expected: the snapshot to hold key, but Got: %q", val)
+	}
+}
+
+func TestShutdownModes(t *testing.T) {
+	s := newServer()
+	for cmd, want := range map[string]int{
+		"SHUTDOWN SAVE":   http.StatusBadRequest, // no snapshot path
+		"SHUTDOWN NOW":    http.StatusBadRequest,
+		"SHUTDOWN NOSAVE": http.StatusOK,
+	} {
+		if status, resp := do(t, s, http.MethodPost, `{"command": "`+cmd+`"}`); status != want {
+			t.Errorf("%v: Expected: %v, but Got: %v %v", cmd, want, status, resp)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := newServer()
+	do(t, s, http.MethodPost, `{"command": "SET key value"}`)
+	do(t, s, http.MethodPost, `{"command": "QPUSH queue a b"}`)
+	do(t, s, http.MethodGet, `{"command": "GET key"}`)
+	do(t, s, http.MethodGet, `{"command": "GET missing"}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Expected: a text response, but Got: %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`kvs_commands_total{command="GET",outcome="ok"} 1`,
+		`kvs_commands_total{command="GET",outcome="client_error"} 1`,
+		`kvs_command_duration_seconds_count{command="SET",outcome="ok"} 1`,
+		`kvs_command_duration_seconds_bucket{command="SET",outcome="ok",le="+Inf"} 1`,
+		`kvs_keys{type="string"} 1`,
+		`kvs_keys{type="queue"} 1`,
+		`kvs_queue_depth 2`,
+		`kvs_blocked_clients 0`,
+		`kvs_expired_keys_total 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected: %s, but Got:\n%s", line, body)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	s := newServer()
+	do(t, s, http.MethodPost, `{"command": "SET key value EX 100"}`)
+	do(t, s, http.MethodPost, `{"command": "QPUSH queue a b"}`)
+	do(t, s, http.MethodGet, `{"command": "GET key"}`)
+	do(t, s, http.MethodGet, `{"command": "GET missing"}`)
+
+	status, resp := do(t, s, http.MethodGet, `{"command": "INFO keyspace stats"}`)
+	info, _ := resp["info"].(map[string]interface{})
+	if status != http.StatusOK || len(info) != 2 {
+		t.Fatalf("Expected: the keyspace and stats sections, but Got: %v %v", status, resp)
+	}
+	keyspace := info["keyspace"].(map[string]interface{})
+	if keyspace["keys"] != float64(2) || keyspace["expires"] != float64(2) || keyspace["queue_depth"] != float64(2) {
+		t.Errorf("Expected: 2 keys with a TTL and 2 queued values, but Got: %v", keyspace)
+	}
+	stats := info["stats"].(map[string]interface{})
+	if stats["keyspace_hits"] != float64(1) || stats["keyspace_misses"] != float64(1) || stats["keyspace_hit_ratio"] != 0.5 {
+		t.Errorf("Expected: 1 hit and 1 miss, but Got: %v", stats)
+	}
+
+	if status, _ := do(t, s, http.MethodGet, `{"command": "INFO nosuch"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected: %v, but Got: %v", http.StatusBadRequest, status)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/info?section=server&section=memory&format=text", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	text := rec.Body.String()
+	if !strings.HasPrefix(text, "# Server\n") || !strings.Contains(text, "\n\n# Memory\n") || !strings.Contains(text, "\nmaxmemory_policy:noeviction\n") {
+		t.Errorf("Expected: the server and memory sections as text, but Got:\n%s", text)
+	}
+}
+
+func TestSlowlog(t *testing.T) {
+	s := newServer()
+	if err := s.SetConfig("slowlog-threshold", "0s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetConfig("slowlog-max-len", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]string, 40)
+	for i := range values {
+		values[i] = "v"
+	}
+	values[0] = strings.Repeat("x", 200)
+	do(t, s, http.MethodPost, `{"command": "SET a 1"}`)
+	do(t, s, http.MethodPost, `{"command": "SET b 2"}`)
+	do(t, s, http.MethodGet, `{"command": "GET a"}`)
+	do(t, s, http.MethodPost, `{"command": "QPUSH queue `+strings.Join(values, " ")+`"}`)
+
+	if _, resp := do(t, s, http.MethodGet, `{"command": "SLOWLOG LEN"}`); resp["len"] != float64(3) {
+		t.Errorf("Expected: the log to hold its maximum of 3, but Got: %v", resp)
+	}
+	_, resp := do(t, s, http.MethodGet, `{"command": "SLOWLOG GET 2"}`)
+	entries, _ := resp["entries"].([]interface{})
+	if len(entries) != 2 {
+		t.Fatalf("Expected: 2 entries, but Got: %v", resp)
+	}
+	entry := entries[1].(map[string]interface{})
+	command := entry["command"].([]interface{})
+	if command[0] != "QPUSH" || len(command) != 32 || command[31] != "... (11 more arguments)" {
+		t.Errorf("Expected: the QPUSH with its arguments truncated, but Got: %v", command)
+	}
+	if arg := command[2].(string); !strings.HasSuffix(arg, "... (72 more bytes)") {
+		t.Errorf("Expected: a long argument to be truncated, but Got: %v", arg)
+	}
+	if entry["id"] != float64(3) || entry["client"] == "" {
+		t.Errorf("Expected: the id and client address, but Got: %v", entry)
+	}
+
+	do(t, s, http.MethodPost, `{"command": "SLOWLOG RESET"}`)
+	if _, resp := do(t, s, http.MethodGet, `{"command": "SLOWLOG LEN"}`); resp["len"] != float64(1) {
+		t.Errorf("Expected: only the SLOWLOG LEN after RESET, but Got: %v", resp)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	s := newServer()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func(prefix string) string {
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), prefix) {
+				return strings.TrimPrefix(lines.Text(), prefix)
+			}
+		}
+		t.Fatalf("stream ended while waiting for %q", prefix)
+		return ""
+	}
+	next("event:monitor")
+
+	do(t, s, http.MethodPost, `{"command": "SET key value"}`)
+	do(t, s, http.MethodPost, `{"command": "NOSUCH key"}`)
+	do(t, s, http.MethodGet, `{"command": "GET key"}`)
+
+	for _, want := range [][]interface{}{{"SET", "key", "value"}, {"GET", "key"}} {
+		next("event:command")
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(next("data:")), &event); err != nil {
+			t.Fatal(err)
+		}
+		command, _ := event["command"].([]interface{})
+		if fmt.Sprint(command) != fmt.Sprint(want) || event["client"] == "" || event["time"] == nil {
+			t.Errorf("Expected: %v with its client and time, but Got: %v", want, event)
+		}
+	}
+}
+
+func TestLogging(t *testing.T) {
+	s := newServer()
+	var logs bytes.Buffer
+	if err := s.SetLogOutput(&logs, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"command": "SET key value"}`))
+	req.Header.Set(server.RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if id := rec.Header().Get(server.RequestIDHeader); id != "req-1" {
+		t.Errorf("Expected: the request ID to be echoed, but Got: %q", id)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("Expected: a JSON log record, but Got: %q", logs.String())
+	}
+	if record["request_id"] != "req-1" || record["status"] != float64(200) || record["level"] != "INFO" {
+		t.Errorf("Expected: the request logged with its ID, but Got: %v", record)
+	}
+
+	if _, resp := do(t, s, http.MethodGet, `{"command": "GET key"}`); resp["value"] != "value" {
+		t.Fatal(resp)
+	}
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Header().Get(server.RequestIDHeader) == "" {
+		t.Errorf("Expected: a generated request ID, but Got: none")
+	}
+
+	logs.Reset()
+	if err := s.SetConfig("loglevel", "warn"); err != nil {
+		t.Fatal(err)
+	}
+	do(t, s, http.MethodGet, `{"command": "GET key"}`)
+	if logs.Len() != 0 {
+		t.Errorf("Expected: no request logs at the warn level, but Got: %q", logs.String())
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	s := newServer()
+	var audit bytes.Buffer
+	s.SetAuditLog(&audit)
+	if err := s.SetConfig("audit-redact", "secret:*"); err != nil {
+		t.Fatal(err)
+	}
+
+	do(t, s, http.MethodPost, `{"command": "SET secret:1 hunter2"}`)
+	do(t, s, http.MethodPost, `{"command": "MSET public visible secret:2 hidden"}`)
+	do(t, s, http.MethodGet, `{"command": "GET public"}`)
+	do(t, s, http.MethodPost, `{"command": "DEL public"}`)
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&audit)
+	for dec.More() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected: the 3 mutating commands, but Got: %v", records)
+	}
+	for i, want := range []string{
+		"SET [secret:1] [secret:1 [REDACTED]]",
+		"MSET [public secret:2] [public visible secret:2 [REDACTED]]",
+		"DEL [public] [public]",
+	} {
+		got := fmt.Sprint(records[i]["command"], " ", records[i]["keys"], " ", records[i]["args"])
+		if got != want || records[i]["client"] == "" || records[i]["request_id"] == nil {
+			t.Errorf("Expected: %s, but Got: %v", want, records[i])
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := newServer()
+	probe := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+		}
+		return rec.Code, resp
+	}
+
+	if status, resp := probe("/healthz"); status != http.StatusOK || resp["status"] != "ok" {
+		t.Errorf("Expected: %v ok, but Got: %v %v", http.StatusOK, status, resp)
+	}
+	status, resp := probe("/readyz")
+	checks, _ := resp["checks"].(map[string]interface{})
+	if status != http.StatusOK || resp["status"] != "ready" || len(checks) != 3 {
+		t.Errorf("Expected: ready with 3 checks, but Got: %v %v", status, resp)
+	}
+
+	do(t, s, http.MethodPost, `{"command": "SET key value"}`)
+	do(t, s, http.MethodPost, `{"command": "CONFIG SET maxmemory 1"}`)
+	status, resp = probe("/readyz")
+	checks, _ = resp["checks"].(map[string]interface{})
+	memory, _ := checks["memory"].(map[string]interface{})
+	if status != http.StatusServiceUnavailable || memory["status"] != "fail" {
+		t.Errorf("Expected: not ready above maxmemory, but Got: %v %v", status, resp)
+	}
+	if status, resp := do(t, s, http.MethodPost, `{"command": "SET other value"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected: %v for a write above maxmemory, but Got: %v %v", http.StatusBadRequest, status, resp)
+	}
+	do(t, s, http.MethodPost, `{"command": "CONFIG SET maxmemory 0"}`)
+}
+
+func TestAuth(t *testing.T) {
+	s := newServer()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(secret string) string {
+		sum := sha256.Sum256([]byte(secret))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	writeUsers := func(tokens ...string) {
+		t.Helper()
+		file := fmt.Sprintf("users:\n  - name: alice\n    passwords: [%q]\n    tokens: [%s]\n", hash, strings.Join(tokens, ", "))
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeUsers(token("tok1"))
+	if err := s.SetAuthFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, strings.NewReader(`{"command": "GET key"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+	basic := func(user, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+
+	for _, tc := range []struct {
+		path, authorization string
+		status              int
+	}{
+		{"/", "", http.StatusUnauthorized},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/healthz", "", http.StatusOK},
+		{"/", "Bearer tok1", http.StatusNotFound},
+		{"/metrics", "bearer tok1", http.StatusOK},
+		{"/", "Bearer nope", http.StatusUnauthorized},
+		{"/", basic("alice", "secret"), http.StatusNotFound},
+		{"/", basic("alice", "secret"), http.StatusNotFound}, // cached
+		{"/", basic("alice", "wrong"), http.StatusUnauthorized},
+		{"/", basic("bob", "secret"), http.StatusUnauthorized},
+	} {
+		rec := request(tc.path, tc.authorization)
+		if rec.Code != tc.status {
+			t.Errorf("%s with %q: Expected: %v, but Got: %v %s", tc.path, tc.authorization, tc.status, rec.Code, rec.Body)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s with %q: Expected: a WWW-Authenticate header, but Got: none", tc.path, tc.authorization)
+		}
+	}
+
+	if status, _ := do(t, s, http.MethodPost, `{"command": "AUTH alice wrong"}`); status != http.StatusUnauthorized {
+		t.Errorf("Expected: %v, but Got: %v", http.StatusUnauthorized, status)
+	}
+	status, resp, session := doSession(t, s, http.MethodPost, `{"command": "AUTH alice secret"}`, "")
+	if status != http.StatusOK || session == "" {
+		t.Fatalf("Expected: a session, but Got: %v %v", status, resp)
+	}
+	if status, _, _ := doSession(t, s, http.MethodPost, `{"command": "SET key value"}`, session); status != http.StatusOK {
+		t.Errorf("Expected: the session to be authenticated, but Got: %v", status)
+	}
+	_, resp = do(t, s, http.MethodPost, `{"commands": ["AUTH tok1", "GET key"]}`)
+	if results := resp["results"].([]interface{}); results[1].(map[string]interface{})["value"] != "value" {
+		t.Errorf("Expected: AUTH to apply to the rest of the pipeline, but Got: %v", results)
+	}
+
+	// Rotating the token: the old one stops working at once.
+	writeUsers(token("tok2"))
+	if err := s.ReloadAuth(); err != nil {
+		t.Fatal(err)
+	}
+	if rec := request("/", "Bearer tok1"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected: the old token to be refused, but Got: %v", rec.Code)
+	}
+	if rec := request("/", "Bearer tok2"); rec.Code != http.StatusOK {
+		t.Errorf("Expected: the new token to be accepted, but Got: %v", rec.Code)
+	}
+	if status, _, _ := doSession(t, s, http.MethodGet, `{"command": "GET key"}`, session); status != http.StatusOK {
+		t.Errorf("Expected: the session to stay authenticated, but Got: %v", status)
+	}
+
+	// A broken file keeps the credentials in effect.
+	if err := os.WriteFile(path, []byte("users:\n  - name: alice\n    tokens: [plain]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadAuth(); err == nil {
+		t.Error("Expected: an error for a plain text token, but Got: nil")
+	}
+	if rec := request("/", "Bearer tok2"); rec.Code != http.StatusOK {
+		t.Errorf("Expected: the previous credentials, but Got: %v", rec.Code)
+	}
+
+	if err := s.SetAuthFile(""); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := do(t, s, http.MethodGet, `{"command": "GET key"}`); status != http.StatusOK {
+		t.Errorf("Expected: no authentication, but Got: %v", status)
+	}
+}
+
+func TestAuthHidesSecrets(t *testing.T) {
+	s := newServer()
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	sum := sha256.Sum256([]byte("tok1"))
+	if err := os.WriteFile(path, []byte("users:\n  - name: alice\n    tokens: [sha256:"+hex.EncodeToString(sum[:])+"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetAuthFile(path); err != nil {
+		t.Fatal(err)
+	}
+	var audit bytes.Buffer
+	s.SetAuditLog(&audit)
+
+	do(t, s, http.MethodPost, `{"commands": ["AUTH tok1", "CONFIG SET slowlog-threshold 0", "AUTH tok1"]}`)
+	_, resp := do(t, s, http.MethodPost, `{"commands": ["AUTH tok1", "SLOWLOG GET"]}`)
+	entries := resp["results"].([]interface{})[1].(map[string]interface{})["entries"]
+	if out, _ := json.Marshal(entries); strings.Contains(string(out), "tok1") || !strings.Contains(string(out), "AUTH") {
+		t.Errorf("Expected: AUTH without its token in the slow log, but Got: %s", out)
+	}
+	if strings.Contains(audit.String(), "tok1") || !strings.Contains(audit.String(), `"user":"alice"`) {
+		t.Errorf("Expected: AUTH without its token in the audit log, but Got: %s", audit.String())
+	}
+}
+
+func TestACL(t *testing.T) {
+	s := newServer()
+	digest := func(secret string) string {
+		sum := sha256.Sum256([]byte(secret))
+		return hex.EncodeToString(sum[:])
+	}
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	users := fmt.Sprintf(`users:
+  - name: admin
+    tokens: [sha256:%s]
+  - name: svc
+    tokens: [sha256:%s]
+    rules: "+@read +@write -del ~svc:* &svc.*"
+`, digest("admin-token"), digest("svc-token"))
+	if err := os.WriteFile(path, []byte(users), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetAuthFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(method, authorization, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	get := func(path, authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	admin, svc := "Bearer admin-token", "Bearer svc-token"
+	send(http.MethodPost, admin, `{"command": "MSET other 1 svc:b 2"}`)
+
+	for _, tc := range []struct {
+		method, body string
+		status       int
+	}{
+		{http.MethodPost, `{"command": "SET svc:a 1"}`, http.StatusOK},
+		{http.MethodPost, `{"command": "SET other 1"}`, http.StatusForbidden},
+		{http.MethodPost, `{"command": "MSET svc:c 1 other 2"}`, http.StatusForbidden},
+		{http.MethodPost, `{"command": "DEL svc:a"}`, http.StatusForbidden},
+		{http.MethodGet, `{"command": "GET svc:a"}`, http.StatusOK},
+		{http.MethodGet, `{"command": "GET other"}`, http.StatusForbidden},
+		{http.MethodPost, `{"command": "QPUSH svc:q 1"}`, http.StatusForbidden},
+		{http.MethodGet, `{"command": "CONFIG GET maxmemory"}`, http.StatusForbidden},
+		{http.MethodPost, `{"command": "PUBLISH svc.events hi"}`, http.StatusOK},
+		{http.MethodPost, `{"command": "PUBLISH news hi"}`, http.StatusForbidden},
+		{http.MethodPost, `{"command": "ACL SETUSER svc allkeys"}`, http.StatusForbidden},
+		{http.MethodPost, `{"command": "UNWATCH"}`, http.StatusOK},
+		{http.MethodPost, `{"command": "DISCARD"}`, http.StatusBadRequest}, // allowed, but without MULTI
+	} {
+		if status, resp := send(tc.method, svc, tc.body); status != tc.status {
+			t.Errorf("%s: Expected: %v, but Got: %v %v", tc.body, tc.status, status, resp)
+		}
+	}
+	if _, resp := send(http.MethodGet, svc, `{"command": "ACL WHOAMI"}`); resp["user"] != "svc" {
+		t.Errorf("Expected: svc, but Got: %v", resp)
+	}
+	if _, resp := send(http.MethodGet, svc, `{"command": "KEYS *"}`); fmt.Sprint(resp["keys"]) != "[svc:a svc:b]" && fmt.Sprint(resp["keys"]) != "[svc:b svc:a]" {
+		t.Errorf("Expected: only the keys of svc, but Got: %v", resp["keys"])
+	}
+	for path, status := range map[string]int{
+		"/metrics":                   http.StatusForbidden,
+		"/scan":                      http.StatusOK,
+		"/subscribe?channel=news":    http.StatusForbidden,
+		"/subscribe?pattern=svc.x.*": http.StatusForbidden,
+	} {
+		if got := get(path, svc); got != status {
+			t.Errorf("%s: Expected: %v, but Got: %v", path, status, got)
+		}
+	}
+	_, resp := send(http.MethodPost, svc, `{"commands": ["MULTI", "SET other 1"]}`)
+	if results, _ := resp["results"].([]interface{}); len(results) != 2 || results[1].(map[string]interface{})["status"] != float64(http.StatusForbidden) {
+		t.Errorf("Expected: the command refused rather than queued, but Got: %v", resp)
+	}
+
+	// Changing the rules takes effect at once.
+	if status, resp := send(http.MethodPost, admin, `{"command": "ACL SETUSER svc +@queue -publish"}`); status != http.StatusOK {
+		t.Fatalf("Expected: %v, but Got: %v %v", http.StatusOK, status, resp)
+	}
+	if status, _ := send(http.MethodPost, svc, `{"command": "QPUSH svc:q 1"}`); status != http.StatusOK {
+		t.Errorf("Expected: QPUSH allowed, but Got: %v", status)
+	}
+	if status, _ := send(http.MethodPost, svc, `{"command": "PUBLISH svc.events hi"}`); status != http.StatusForbidden {
+		t.Errorf("Expected: PUBLISH denied, but Got: %v", status)
+	}
+
+	if status, resp := send(http.MethodPost, admin, `{"command": "ACL SETUSER bob on >pw +@read ~*"}`); status != http.StatusOK {
+		t.Fatalf("Expected: %v, but Got: %v %v", http.StatusOK, status, resp)
+	}
+	bob := "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:pw"))
+	if status, _ := send(http.MethodGet, bob, `{"command": "GET other"}`); status != http.StatusOK {
+		t.Errorf("Expected: bob to read every key, but Got: %v", status)
+	}
+	_, resp = send(http.MethodGet, admin, `{"command": "ACL GETUSER bob"}`)
+	acl, _ := resp["acl"].(map[string]interface{})
+	if acl["commands"] != "-@all +@read" || fmt.Sprint(acl["flags"]) != "[on]" || fmt.Sprint(acl["keys"]) != "[*]" {
+		t.Errorf("Expected: bob's rules, but Got: %v", resp)
+	}
+	_, resp = send(http.MethodGet, admin, `{"command": "ACL LIST"}`)
+	want := []interface{}{
+		"user admin on ~* &* +@all",
+		"user bob on #" + digest("pw") + " ~* -@all +@read",
+		"user svc on ~svc:* &svc.* -@all +@queue +@read +@write -del -publish",
+	}
+	if fmt.Sprint(resp["users"]) != fmt.Sprint(want) {
+		t.Errorf("Expected: %v, but Got: %v", want, resp["users"])
+	}
+	if status, _ := send(http.MethodPost, admin, `{"command": "ACL SETUSER bob +@nope"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected: %v, but Got: %v", http.StatusBadRequest, status)
+	}
+	send(http.MethodPost, admin, `{"command": "ACL SETUSER bob off"}`)
+	if status, _ := send(http.MethodGet, bob, `{"command": "GET other"}`); status != http.StatusUnauthorized {
+		t.Errorf("Expected: a disabled user to be refused, but Got: %v", status)
+	}
+}
+
+// testCA issues certificates for TestTLS.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for the server at 127.0.0.1 or for a client.
+func (ca *testCA) issue(t *testing.T, commonName string, serial int64, client bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	cert, err := tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writePEM(t *testing.T, dir string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	keyDER, _ := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writePEM(t, dir, ca.issue(t, "server", 10, false))
+
+	usersFile := filepath.Join(dir, "users.yaml")
+	if err := os.WriteFile(usersFile, []byte("users:\n  - name: svc\n    rules: \"+@read ~*\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newServer()
+	if err := s.SetAuthFile(usersFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetTLS(certFile, keyFile, caFile, server.TLSAuthClientsOptional); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	defer func() {
+		s.RequestShutdown(server.ShutdownNoSave)
+		<-served
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	get := func(certs ...tls.Certificate) (int, *x509.Certificate) {
+		t.Helper()
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}
+		defer transport.CloseIdleConnections()
+		req, _ := http.NewRequest(http.MethodGet, "https://"+l.Addr().String()+"/", strings.NewReader(`{"command": "ACL WHOAMI"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, resp.TLS.PeerCertificates[0]
+	}
+
+	if status, cert := get(); status != http.StatusUnauthorized || cert.SerialNumber.Int64() != 10 {
+		t.Errorf("Expected: 401 from certificate 10, but Got: %v from %v", status, cert.SerialNumber)
+	}
+	if status, _ := get(ca.issue(t, "svc", 20, true)); status != http.StatusOK {
+		t.Errorf("Expected: the certificate to authenticate svc, but Got: %v", status)
+	}
+	if status, _ := get(ca.issue(t, "stranger", 21, true)); status != http.StatusUnauthorized {
+		t.Errorf("Expected: an unknown user to be refused, but Got: %v", status)
+	}
+
+	// A renewed certificate is served once its files change and the server
+	// next checks them, at most a second later.
+	writePEM(t, dir, ca.issue(t, "server", 11, false))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	time.Sleep(1100 * time.Millisecond)
+	if _, cert := get(); cert.SerialNumber.Int64() != 11 {
+		t.Errorf("Expected: certificate 11, but Got: %v", cert.SerialNumber)
+	}
+
+	// A broken certificate keeps the previous one in use.
+	os.WriteFile(certFile, []byte("garbage"), 0o600)
+	time.Sleep(1100 * time.Millisecond)
+	if _, cert := get(); cert.SerialNumber.Int64() != 11 {
+		t.Errorf("Expected: certificate 11, but Got: %v", cert.SerialNumber)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := newServer()
+	if err := s.SetConfig("ratelimit-write", "2/m"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetConfig("ratelimit", "often"); err == nil {
+		t.Errorf("Expected: an error for an invalid rate limit, but Got: none")
+	}
+
+	request := func(method, path, body, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := request(http.MethodPost, "/", `{"command": "SET key value"}`, "192.0.2.1:1000"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected: %d, but Got: %d", http.StatusOK, rec.Code)
+		}
+	}
+	// The limit is per client, not per connection.
+	rec := request(http.MethodPost, "/", `{"command": "SET key value"}`, "192.0.2.1:2000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected: %d, but Got: %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if retry := rec.Header().Get("Retry-After"); retry != "30" {
+		t.Errorf("Expected: Retry-After 30, but Got: %q", retry)
+	}
+
+	// Other commands and other clients are not limited.
+	if rec := request(http.MethodGet, "/", `{"command": "GET key"}`, "192.0.2.1:1000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected: %d, but Got: %d", http.StatusOK, rec.Code)
+	}
+	if rec := request(http.MethodPost, "/", `{"command": "SET key value"}`, "192.0.2.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected: %d, but Got: %d", http.StatusOK, rec.Code)
+	}
+
+	// Each command of a pipeline counts.
+	rec = request(http.MethodPost, "/", `{"commands": ["SET a 1", "SET b 2", "SET c 3"]}`, "192.0.2.3:1000")
+	var pipeline struct {
+		Results []struct{ Status int } `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &pipeline); err != nil {
+		t.Fatal(err)
+	}
+	if len(pipeline.Results) != 3 || pipeline.Results[2].Status != http.StatusTooManyRequests {
+		t.Errorf("Expected: the third command throttled, but Got: %s", rec.Body.String())
+	}
+
+	// Routes count against the limits too.
+	if err := s.SetConfig("ratelimit-admin", "1/h"); err != nil {
+		t.Fatal(err)
+	}
+	request(http.MethodGet, "/info", "", "192.0.2.1:1000")
+	rec = request(http.MethodGet, "/metrics", "", "192.0.2.1:1000")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected: %d with Retry-After, but Got: %d %v", http.StatusTooManyRequests, rec.Code, rec.Header())
+	}
+	if err := s.SetConfig("ratelimit-admin", "0"); err != nil {
+		t.Fatal(err)
+	}
+	metrics := request(http.MethodGet, "/metrics", "", "192.0.2.1:1000").Body.String()
+	for _, want := range []string{
+		`kvs_throttled_commands_total{limit="write"} 2`,
+		`kvs_throttled_commands_total{limit="admin"} 1`,
+		`kvs_throttled_commands_total{limit="global"} 0`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("Expected: %s in the metrics, but Got:\n%s", want, metrics)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	s := newServer()
+	if err := s.SetConfig("tenant-quotas", "t1:=2/0,t2:=0/1kb"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.TenantQuotas(); got != "t1:=2/0,t2:=0/1024" {
+		t.Errorf("Expected: t1:=2/0,t2:=0/1024, but Got: %s", got)
+	}
+	if err := s.SetConfig("tenant-quotas", "t1:=2"); err == nil {
+		t.Errorf("Expected: an error for an invalid quota, but Got: none")
+	}
+
+	large := strings.Repeat("x", 2048)
+	for _, tc := range []struct {
+		command string
+		status  int
+	}{
+		{"SET t1:a 1", http.StatusOK},
+		{"SET t1:b 2", http.StatusOK},
+		{"SET t1:c 3", http.StatusInsufficientStorage},
+		{"SET t1:a 4", http.StatusOK}, // not a new key
+		{"SET other 5", http.StatusOK},
+		{"DEL t1:b", http.StatusOK},
+		{"MSET t1:c 1 t1:d 2", http.StatusInsufficientStorage},
+		{"MSET t1:c 1 other 2", http.StatusOK},
+		{"SET t2:large " + large, http.StatusInsufficientStorage}, // over the quota by itself
+		{"SET t2:small 1", http.StatusOK},
+		{"QPUSH t2:queue " + large[:1000], http.StatusInsufficientStorage},
+	} {
+		if status, resp := do(t, s, http.MethodPost, fmt.Sprintf(`{"command": %q}`, tc.command)); status != tc.status {
+			t.Errorf("%s: Expected: %d, but Got: %d %v", tc.command, tc.status, status, resp)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	for _, want := range []string{
+		`kvs_tenant_keys{tenant="t1:"} 2`,
+		`kvs_tenant_keys{tenant="t2:"} 1`,
+		`kvs_quota_rejected_commands_total{tenant="t1:",resource="keys"} 2`,
+		`kvs_quota_rejected_commands_total{tenant="t2:",resource="memory"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("Expected: %s in the metrics, but Got:\n%s", want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionHeader carries the session token between requests. It is handed out
+// by MULTI or WATCH and must be sent back with every command that belongs to
+// the same transaction.
+const SessionHeader = "X-Session-Id"
+
+// sessionIdleTimeout is how long a session may go unused before it is
+// dropped along with any queued commands and watched keys.
+const sessionIdleTimeout = 5 * time.Minute
+
+// session is the per-client transaction state that would live on the
+// connection in a stateful protocol.
+type session struct {
+	mu       sync.Mutex // held while a request for this session runs
+	id       string
+	inMulti  bool
+	queued   []string
+	watched  map[string]uint64 // key -> version at WATCH time
+	lastUsed time.Time
+	user     atomic.Value // string, set by AUTH
+}
+
+// reset ends the transaction and forgets the watched keys.
+func (sess *session) reset() {
+	sess.inMulti = false
+	sess.queued = nil
+	sess.watched = nil
+}
+
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{sessions: make(map[string]*session)}
+}
+
+// create starts a new session, dropping any that have been idle too long.
+func (t *sessionTable) create() *session {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for id, sess := range t.sessions {
+		if now.Sub(sess.lastUsed) > sessionIdleTimeout {
+			delete(t.sessions, id)
+		}
+	}
+
+	sess := &session{id: hex.EncodeToString(buf), lastUsed: now}
+	t.sessions[sess.id] = sess
+	return sess
+}
+
+func (t *sessionTable) get(id string) (*session, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sess, ok := t.sessions[id]
+	if !ok || time.Since(sess.lastUsed) > sessionIdleTimeout {
+		delete(t.sessions, id)
+		return nil, false
+	}
+	sess.lastUsed = time.Now()
+	return sess, true
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultShutdownTimeout is how long a shutdown waits for in-flight commands
+// before closing their connections.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Shutdown modes, as given to SHUTDOWN and RequestShutdown. The default mode
+// saves a snapshot if a snapshot path is configured.
+const (
+	ShutdownDefault = ""
+	ShutdownSave    = "SAVE"
+	ShutdownNoSave  = "NOSAVE"
+)
+
+// errShuttingDown is reported to commands cut short by a shutdown.
+var errShuttingDown = errors.New("server is shutting down")
+
+// Run listens on addr and serves until a shutdown is requested, see Serve.
+func (s *Server) Run(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves on l, over TLS if SetTLS was called, until a shutdown is
+// requested with RequestShutdown or SHUTDOWN. It then stops accepting connections, wakes blocked BQPOPs and
+// subscribers, waits up to the shutdown timeout for in-flight commands and
+// saves a snapshot as the shutdown mode says. It returns nil once all of
+// that succeeded.
+func (s *Server) Serve(l net.Listener) error {
+	httpServer := &http.Server{
+		Handler:     s,
+		BaseContext: func(net.Listener) context.Context { return s.baseCtx },
+		ConnState:   s.trackConn,
+	}
+
+	served := make(chan error, 1)
+	if s.tls != nil {
+		httpServer.TLSConfig = s.tlsConfig()
+		go func() { served <- httpServer.ServeTLS(l, "", "") }()
+	} else {
+		go func() { served <- httpServer.Serve(l) }()
+	}
+
+	var mode string
+	select {
+	case err := <-served:
+		return err
+	case mode = <-s.shutdownCh:
+	}
+
+	s.Logger().Info("shutting down", "timeout", s.ShutdownTimeout(), "mode", mode)
+	s.closing.Store(true)
+	s.cancelBase()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout())
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		s.Logger().Warn("closing the connections of unfinished commands", "err", err)
+		httpServer.Close()
+	}
+
+	// Commands still running past the deadline are waited for here, so the
+	// snapshot never catches a transaction half applied.
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	path := s.SnapshotPath()
+	if mode == ShutdownNoSave || path == "" {
+		return nil
+	}
+	if s.loading.Load() {
+		// Saving now would replace the snapshot with part of it.
+		s.Logger().Warn("not saving, the snapshot is still loading", "path", path)
+		return nil
+	}
+	return s.store.SaveFile(path)
+}
+
+// trackConn counts the open connections for INFO.
+func (s *Server) trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.connections.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		s.connections.Add(-1)
+	}
+}
+
+// RequestShutdown asks Serve to shut down in the given mode. Only the first
+// request counts.
+func (s *Server) RequestShutdown(mode string) error {
+	mode = strings.ToUpper(mode)
+	switch mode {
+	case ShutdownDefault, ShutdownNoSave:
+	case ShutdownSave:
+		if s.SnapshotPath() == "" {
+			return errors.New("no snapshot path is configured")
+		}
+	default:
+		return errors.New("invalid shutdown mode: " + mode)
+	}
+
+	select {
+	case s.shutdownCh <- mode:
+	default: // already requested
+	}
+	return nil
+}
+
+// SetSnapshotPath makes shutdowns save the snapshot to path, or not at all
+// if it is empty.
+func (s *Server) SetSnapshotPath(path string) {
+	s.snapshotPath.Store(path)
+}
+
+// SnapshotPath returns where a shutdown saves the snapshot, "" for nowhere.
+func (s *Server) SnapshotPath() string {
+	return s.snapshotPath.Load().(string)
+}
+
+// ShutdownTimeout returns how long a shutdown waits for in-flight commands.
+func (s *Server) ShutdownTimeout() time.Duration {
+	return time.Duration(s.shutdownTimeout.Load())
+}
+
+// refuseWhileClosing answers requests that arrive on open connections once
+// a shutdown has started.
+func (s *Server) refuseWhileClosing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.closing.Load() {
+			c.Header("Connection", "close")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": errShuttingDown.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// shutdownCommand implements SHUTDOWN [SAVE|NOSAVE]. The reply is sent
+// before the server goes down, as Serve waits for in-flight commands.
+func (s *Server) shutdownCommand(contents []string) Result {
+	if len(contents) > 1 {
+		return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for shutdown"}}
+	}
+	mode := ShutdownDefault
+	if len(contents) == 1 {
+		mode = contents[0]
+	}
+	if err := s.RequestShutdown(mode); err != nil {
+		return Result{http.StatusBadRequest, gin.H{"error": err.Error()}}
+	}
+	return Result{http.StatusOK, gin.H{"message": "OK"}}
+}
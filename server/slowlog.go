@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Defaults of the slowlog-threshold and slowlog-max-len settings.
+const (
+	DefaultSlowlogThreshold = 10 * time.Millisecond
+	DefaultSlowlogMaxLen    = 128
+)
+
+// Limits on the arguments kept for each slow command, so that a QPUSH of
+// thousands of values does not make the log itself big.
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowEntry is one command recorded by the slow log.
+type slowEntry struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Duration int64     `json:"duration_us"`
+	Command  []string  `json:"command"`
+	Client   string    `json:"client"`
+}
+
+// slowlog keeps the latest commands that ran for at least threshold, in a
+// ring of maxLen entries.
+type slowlog struct {
+	threshold atomic.Int64 // time.Duration, negative to disable
+
+	mu      sync.Mutex
+	entries []slowEntry // ring, oldest at start once full
+	start   int
+	maxLen  int
+	nextID  uint64
+}
+
+func newSlowlog() *slowlog {
+	l := &slowlog{maxLen: DefaultSlowlogMaxLen}
+	l.threshold.Store(int64(DefaultSlowlogThreshold))
+	return l
+}
+
+// record logs the command if it ran for at least the threshold. Faster
+// commands only cost an atomic load.
+func (l *slowlog) record(operation string, contents []string, client string, d time.Duration) {
+	threshold := time.Duration(l.threshold.Load())
+	if threshold < 0 || d < threshold {
+		return
+	}
+	entry := slowEntry{
+		Time:     time.Now(),
+		Duration: d.Microseconds(),
+		Command:  truncateArgs(operation, contents),
+		Client:   client,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.ID = l.nextID
+	l.nextID++
+	if len(l.entries) < l.maxLen {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	if l.maxLen == 0 {
+		return
+	}
+	l.entries[l.start] = entry
+	l.start = (l.start + 1) % l.maxLen
+}
+
+// truncateArgs copies the command, shortening long arguments and dropping
+// the ones past slowlogMaxArgs, as Redis does.
+func truncateArgs(operation string, contents []string) []string {
+	n := len(contents)
+	if n > slowlogMaxArgs-1 {
+		n = slowlogMaxArgs - 2 // leaves room for the note below
+	}
+	args := make([]string, 0, n+2)
+	args = append(args, operation)
+	for _, arg := range contents[:n] {
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		args = append(args, arg)
+	}
+	if n < len(contents) {
+		args = append(args, fmt.Sprintf("... (%d more arguments)", len(contents)-n))
+	}
+	return args
+}
+
+// latest returns up to count entries, newest first.
+func (l *slowlog) latest(count int) []slowEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if count > len(l.entries) || count < 0 {
+		count = len(l.entries)
+	}
+	out := make([]slowEntry, count)
+	for i := range out {
+		out[i] = l.entries[(l.start+len(l.entries)-1-i)%len(l.entries)]
+	}
+	return out
+}
+
+func (l *slowlog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+func (l *slowlog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries, l.start = nil, 0
+}
+
+// setMaxLen resizes the ring, keeping the newest entries that still fit.
+func (l *slowlog) setMaxLen(n int) error {
+	if n < 0 {
+		return errors.New("invalid slowlog length: " + strconv.Itoa(n))
+	}
+	kept := l.latest(n)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = make([]slowEntry, len(kept), n)
+	for i, entry := range kept {
+		l.entries[len(kept)-1-i] = entry
+	}
+	l.start, l.maxLen = 0, n
+	return nil
+}
+
+func (l *slowlog) getMaxLen() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.maxLen
+}
+
+// slowlogCommand implements SLOWLOG GET [count], SLOWLOG LEN and SLOWLOG
+// RESET.
+func (s *Server) slowlogCommand(contents []string) Result {
+	if len(contents) == 0 {
+		return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for slowlog"}}
+	}
+
+	switch strings.ToUpper(contents[0]) {
+	case "GET":
+		count := 10
+		switch len(contents) {
+		case 1:
+		case 2:
+			n, err := strconv.Atoi(contents[1])
+			if err != nil {
+				return Result{http.StatusBadRequest, gin.H{"error": "invalid count"}}
+			}
+			count = n // negative returns every entry
+		default:
+			return Result{http.StatusBadRequest, gin.H{"error": "invalid number of arguments for slowlog get"}}
+		}
+		return Result{http.StatusOK, gin.H{"entries": s.slowlog.latest(count)}}
+
+	case "LEN":
+		return Result{http.StatusOK, gin.H{"len": s.slowlog.len()}}
+
+	case "RESET":
+		s.slowlog.reset()
+		return Result{http.StatusOK, gin.H{"message": "OK"}}
+	}
+
+	return Result{http.StatusBadRequest, gin.H{"error": "invalid command"}}
+}
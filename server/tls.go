@@ -0,0 +1,179 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client certificate modes of SetTLS, named after Redis' tls-auth-clients.
+const (
+	TLSAuthClientsNo       = "no"
+	TLSAuthClientsOptional = "optional"
+	TLSAuthClientsYes      = "yes"
+)
+
+// tlsCheckInterval is how often the TLS files are checked for changes, so
+// that handshakes do not each stat them.
+const tlsCheckInterval = time.Second
+
+// tlsFiles serves the certificate and the client CAs read from files,
+// reading them again once one of them changed, so that renewed
+// certificates are picked up without a restart.
+type tlsFiles struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+	server                    *Server // for logging
+
+	config    atomic.Pointer[tls.Config]
+	lastCheck atomic.Int64 // Unix nanoseconds
+
+	mu     sync.Mutex // serializes reloads
+	stamps [3]fileStamp
+}
+
+// fileStamp tells whether a file changed.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stamp(path string) fileStamp {
+	if path == "" {
+		return fileStamp{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info.ModTime(), info.Size()}
+}
+
+func (f *tlsFiles) currentStamps() [3]fileStamp {
+	return [3]fileStamp{stamp(f.certFile), stamp(f.keyFile), stamp(f.caFile)}
+}
+
+// load reads the files into a new config. On error the config in effect is
+// kept.
+func (f *tlsFiles) load() error {
+	stamps := f.currentStamps()
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if f.caFile != "" {
+		pem, err := os.ReadFile(f.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New(f.caFile + ": no certificates found")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = f.clientAuth
+	}
+	f.config.Store(config)
+	f.stamps = stamps
+	return nil
+}
+
+// current returns the config to use for a new connection, reloading the
+// files first if they changed. They are checked at most once every
+// tlsCheckInterval, by whichever handshake comes first.
+func (f *tlsFiles) current() *tls.Config {
+	now := time.Now().UnixNano()
+	last := f.lastCheck.Load()
+	if now-last >= int64(tlsCheckInterval) && f.lastCheck.CompareAndSwap(last, now) {
+		f.reloadIfChanged()
+	}
+	return f.config.Load()
+}
+
+func (f *tlsFiles) reloadIfChanged() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.currentStamps() == f.stamps {
+		return
+	}
+	if err := f.load(); err != nil {
+		// Likely caught between writing the certificate and the key; the
+		// next check tries again.
+		f.server.Logger().Warn("reloading the TLS certificate failed", "err", err)
+	} else {
+		f.server.Logger().Info("TLS certificate reloaded", "cert", f.certFile)
+	}
+}
+
+// SetTLS makes Serve accept TLS connections only, with the certificate and
+// key in the given PEM files. If caFile is set, clients present
+// certificates signed by one of its CAs, as authClients says: "yes" to
+// require one, "optional" to check it only if given, "no" to not ask. The
+// common name of a verified client certificate is the user its requests are
+// authenticated as, see SetAuthFile.
+//
+// The files are read right away and again whenever they change. It must be
+// called before the server is started.
+func (s *Server) SetTLS(certFile, keyFile, caFile, authClients string) error {
+	f := &tlsFiles{certFile: certFile, keyFile: keyFile, caFile: caFile, server: s}
+	switch authClients {
+	case TLSAuthClientsNo:
+		f.clientAuth = tls.NoClientCert
+	case TLSAuthClientsOptional:
+		f.clientAuth = tls.VerifyClientCertIfGiven
+	case TLSAuthClientsYes:
+		f.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return errors.New("invalid tls-auth-clients: " + authClients)
+	}
+	if err := f.load(); err != nil {
+		return err
+	}
+	f.lastCheck.Store(time.Now().UnixNano())
+	s.tls = f
+	return nil
+}
+
+// ReloadTLS reads the TLS files again, even if they look unchanged.
+func (s *Server) ReloadTLS() error {
+	if s.tls == nil {
+		return nil
+	}
+	s.tls.mu.Lock()
+	defer s.tls.mu.Unlock()
+	return s.tls.load()
+}
+
+// tlsConfig returns the config of the listener, which takes that of each
+// connection from the files.
+func (s *Server) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.tls.current(), nil
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.tls.current().Certificates[0], nil
+		},
+	}
+}
+
+// certUser returns the user named by the verified client certificate of r,
+// if any.
+func certUser(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}